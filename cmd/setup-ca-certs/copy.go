@@ -0,0 +1,223 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dataDirName is the symlink pointing at the active payload directory.
+// The layout mirrors the one kubelet uses for ConfigMap and Secret volumes:
+//
+//	..data -> ..2006_01_02_15_04_05.000000000
+//	ca-certificates.crt -> ..data/ca-certificates.crt
+//
+// so that readers always observe a complete trust store.
+const (
+	dataDirName    = "..data"
+	newDataDirName = "..data_tmp"
+	payloadPrefix  = ".."
+)
+
+// WriteAtomic writes the content of src into dest by copying it into a new
+// payload directory and swapping the ..data symlink to it. Symlinks pointing
+// inside src are preserved, the ones pointing outside of it are copied as
+// regular files. It is safe to run again on the same dest.
+func WriteAtomic(src, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	payload, err := ioutil.TempDir(dest, payloadPrefix+time.Now().UTC().Format("2006_01_02_15_04_05."))
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(payload, 0755); err != nil {
+		return err
+	}
+	if err := copyTree(src, src, payload); err != nil {
+		os.RemoveAll(payload)
+		return err
+	}
+	if err := syncDir(payload); err != nil {
+		os.RemoveAll(payload)
+		return err
+	}
+
+	// Swap the ..data symlink, rename is atomic.
+	newDataDir := filepath.Join(dest, newDataDirName)
+	os.Remove(newDataDir)
+	if err := os.Symlink(filepath.Base(payload), newDataDir); err != nil {
+		return err
+	}
+	if err := os.Rename(newDataDir, filepath.Join(dest, dataDirName)); err != nil {
+		return err
+	}
+
+	if err := linkPayload(payload, dest); err != nil {
+		return err
+	}
+	if err := syncDir(dest); err != nil {
+		return err
+	}
+	return removeStale(dest, filepath.Base(payload))
+}
+
+// copyTree copies dir, a directory below root, into dest.
+func copyTree(root, dir, dest string) error {
+	fds, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range fds {
+		srcPath := filepath.Join(dir, fd.Name())
+		destPath := filepath.Join(dest, fd.Name())
+
+		switch {
+		case fd.Mode()&os.ModeSymlink != 0:
+			target, inside, err := resolveLink(root, srcPath)
+			if err != nil {
+				return err
+			}
+			if inside {
+				rel, err := filepath.Rel(filepath.Dir(srcPath), target)
+				if err != nil {
+					return err
+				}
+				if err := os.Symlink(rel, destPath); err != nil {
+					return err
+				}
+			} else if err := copyFile(target, destPath); err != nil {
+				return err
+			}
+		case fd.IsDir():
+			if err := os.Mkdir(destPath, fd.Mode().Perm()); err != nil {
+				return err
+			}
+			if err := copyTree(root, srcPath, destPath); err != nil {
+				return err
+			}
+		default:
+			if err := copyFile(srcPath, destPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveLink returns the absolute target of the symlink at path, and whether
+// it stays within root.
+func resolveLink(root, path string) (string, bool, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", false, err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return "", false, err
+	}
+	return target, rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+func copyFile(src, dest string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	destFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	if _, err = io.Copy(destFile, srcFile); err != nil {
+		return err
+	}
+	return destFile.Sync()
+}
+
+// linkPayload points a top level symlink into ..data at every entry of payload.
+func linkPayload(payload, dest string) error {
+	fds, err := ioutil.ReadDir(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range fds {
+		link := filepath.Join(dest, fd.Name())
+		target := filepath.Join(dataDirName, fd.Name())
+		if current, err := os.Readlink(link); err == nil && current == target {
+			continue
+		}
+
+		tmp := link + ".tmp"
+		os.Remove(tmp)
+		if err := os.Symlink(target, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStale removes previous payload directories and the top level
+// symlinks which no longer resolve into the current one.
+func removeStale(dest, current string) error {
+	fds, err := ioutil.ReadDir(dest)
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range fds {
+		name := fd.Name()
+		path := filepath.Join(dest, name)
+
+		switch {
+		case name == dataDirName || name == current:
+		case fd.IsDir() && strings.HasPrefix(name, payloadPrefix):
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		case fd.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(target, dataDirName+string(filepath.Separator)) {
+				continue
+			}
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				if err := os.Remove(path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	spec.Run(t, "WriteAtomic", testWriteAtomic)
+}
+
+func testWriteAtomic(t *testing.T, when spec.G, it spec.S) {
+	var src, outside, dest string
+
+	it.Before(func() {
+		src = t.TempDir()
+		outside = t.TempDir()
+		dest = t.TempDir()
+
+		require.NoError(t, ioutil.WriteFile(filepath.Join(outside, "some.crt"), []byte("some-cert"), 0644))
+		require.NoError(t, os.Symlink(filepath.Join(outside, "some.crt"), filepath.Join(src, "some.pem")))
+		require.NoError(t, os.Symlink("some.pem", filepath.Join(src, "1234abcd.0")))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src, "ca-certificates.crt"), []byte("some-bundle"), 0644))
+	})
+
+	it("preserves links within the source and copies links pointing outside of it", func() {
+		require.NoError(t, WriteAtomic(src, dest))
+
+		target, err := os.Readlink(filepath.Join(dest, dataDirName, "1234abcd.0"))
+		require.NoError(t, err)
+		assert.Equal(t, "some.pem", target)
+
+		info, err := os.Lstat(filepath.Join(dest, dataDirName, "some.pem"))
+		require.NoError(t, err)
+		assert.True(t, info.Mode().IsRegular())
+
+		b, err := ioutil.ReadFile(filepath.Join(dest, "1234abcd.0"))
+		require.NoError(t, err)
+		assert.Equal(t, "some-cert", string(b))
+	})
+
+	it("replaces a previous payload when run again", func() {
+		require.NoError(t, WriteAtomic(src, dest))
+
+		require.NoError(t, os.Remove(filepath.Join(src, "1234abcd.0")))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src, "ca-certificates.crt"), []byte("other-bundle"), 0644))
+		require.NoError(t, WriteAtomic(src, dest))
+
+		b, err := ioutil.ReadFile(filepath.Join(dest, "ca-certificates.crt"))
+		require.NoError(t, err)
+		assert.Equal(t, "other-bundle", string(b))
+
+		_, err = os.Lstat(filepath.Join(dest, "1234abcd.0"))
+		assert.True(t, os.IsNotExist(err))
+
+		fds, err := ioutil.ReadDir(dest)
+		require.NoError(t, err)
+		var payloads int
+		for _, fd := range fds {
+			if fd.IsDir() {
+				payloads++
+			}
+		}
+		assert.Equal(t, 1, payloads)
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/zezaeoh/knurse/internal/certs"
+)
+
+// WriteHashes writes every certificate of data into dir, named by its OpenSSL
+// subject hash, so that single files can be mounted into hashed directories.
+func WriteHashes(dir, data string) error {
+	names, err := certs.HashFiles([]byte(data))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	rest := []byte(data)
+	for _, name := range names {
+		var block *pem.Block
+		for block == nil || block.Type != "CERTIFICATE" {
+			block, rest = pem.Decode(rest)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const certificate = `-----BEGIN CERTIFICATE-----
+MIIBbjCCAROgAwIBAgIQE6ttkwhxiyWXdGyCYeXx2TAKBggqhkjOPQQDAjAVMRMw
+EQYDVQQDEwp6ZXphZW9oLmlvMB4XDTIyMDMwMTExMDMxM1oXDTMyMDIyNzExMDMx
+M1owFTETMBEGA1UEAxMKemV6YWVvaC5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEH
+A0IABHX/JsHeUP4N3nqPrvxomMfEAZuVNZ4gqUxkYfZ4zBeInce/l0VJ3zs6T1UF
+CCrfz4Ikh808Hqn0WOkuuTrjAfqjRTBDMA4GA1UdDwEB/wQEAwIBBjASBgNVHRMB
+Af8ECDAGAQH/AgEBMB0GA1UdDgQWBBRZCI0gAEYflEredZJdcb4g8TaCSzAKBggq
+hkjOPQQDAgNJADBGAiEA6r77RFykldPNKKIzyazuDjQltBQpP5FXJH8u3jDx3tYC
+IQDo8vaYB9ySwxkM4aQvEZVvOUZf/uYVkwenThmIxDbw8w==
+-----END CERTIFICATE-----`
+
+func TestWriteHashes(t *testing.T) {
+	spec.Run(t, "WriteHashes", testWriteHashes)
+}
+
+func testWriteHashes(t *testing.T, when spec.G, it spec.S) {
+	it("writes every certificate named by its subject hash", func() {
+		dir := filepath.Join(t.TempDir(), "hashes")
+		require.NoError(t, WriteHashes(dir, certificate+"\n"+certificate+"\n"))
+
+		for _, name := range []string{"1e189625.0", "1e189625.1"} {
+			b, err := ioutil.ReadFile(filepath.Join(dir, name))
+			require.NoError(t, err)
+			assert.Equal(t, certificate+"\n", string(b))
+		}
+	})
+}
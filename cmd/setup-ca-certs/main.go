@@ -1,126 +1,342 @@
 package main
 
 import (
-	"github.com/zezaeoh/knurse/internal/enum"
-	"io"
+	"errors"
+	"flag"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/zezaeoh/knurse/internal/certs"
+	"github.com/zezaeoh/knurse/internal/enum"
+)
+
+// Exit codes distinguishing the failure classes of setup-ca-certs, away from
+// the codes of the Go runtime (2 on panic) and of the shell.
+const (
+	exitParseError  = 10
+	exitIOError     = 11
+	exitVerifyError = 12
 )
 
+// Output formats.
+const (
+	// formatStore writes the trust store generated by update-ca-certificates,
+	// including the hash links used for OpenSSL style lookups.
+	formatStore = "store"
+	// formatBundle writes only the concatenated ca-certificates.crt bundle.
+	formatBundle = "bundle"
+	// formatManifest writes the trust manifest.
+	formatManifest = "manifest"
+	// formatHashes writes the given certificates named by their subject hash.
+	formatHashes = "hashes"
+)
+
+// Modes.
+const (
+	// modeMerge adds the certificates to the CA certificates of the image.
+	modeMerge = "merge"
+	// modeReplace trusts the given certificates only.
+	modeReplace = "replace"
+)
+
+const bundleFile = "ca-certificates.crt"
+
+type options struct {
+	data       string
+	dataFile   string
+	digest     string
+	bundleName string
+	outputDir  string
+	formats    []string
+	mode       string
+	dryRun     bool
+
+	// digestRequired is set when the data comes from the environment set by
+	// the webhook, not from --data or --data-file.
+	digestRequired bool
+}
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func parseError(err error) error {
+	return &exitError{code: exitParseError, err: err}
+}
+
+func ioError(err error) error {
+	return &exitError{code: exitIOError, err: err}
+}
+
+func verifyError(err error) error {
+	return &exitError{code: exitVerifyError, err: err}
+}
+
 func main() {
-	logger := log.New(os.Stdout, "", 0)
+	logger := newLogger()
+	defer logger.Sync()
 
-	tempLocal, err := ioutil.TempDir("", "local")
+	opts, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err == nil {
+		err = run(logger, opts)
+	}
 	if err != nil {
-		log.Fatal(err)
+		code := exitIOError
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.code
+		}
+		logger.Errorw("Failed to set up CA certificates", zap.Error(err), zap.Int("exitCode", code))
+		logger.Sync()
+		os.Exit(code)
 	}
-	defer os.RemoveAll(tempLocal)
+}
 
-	tempCerts, err := ioutil.TempDir("", "certs")
+func newLogger() *zap.SugaredLogger {
+	cfg := zap.NewProductionConfig()
+	cfg.OutputPaths = []string{"stdout"}
+	cfg.Sampling = nil
+	logger, err := cfg.Build()
 	if err != nil {
-		log.Fatal(err)
+		panic(err)
 	}
-	defer os.RemoveAll(tempCerts)
+	return logger.Sugar()
+}
 
-	logger.Println("Create certificate...")
-	file, err := os.Create(filepath.Join(tempLocal, "cert-injection-webhook.crt"))
-	if err != nil {
-		log.Fatal(err)
+// parseFlags parses the command line. Flags default to the environment set by the webhook.
+func parseFlags(args []string) (*options, error) {
+	opts := &options{}
+	var formats string
+
+	fs := flag.NewFlagSet("setup-ca-certs", flag.ContinueOnError)
+	fs.StringVar(&opts.data, "data", os.Getenv(enum.SETUP_CA_CERT_DATA), "PEM encoded CA certificates to add")
+	fs.StringVar(&opts.dataFile, "data-file", "", "File to read the PEM encoded CA certificates from, - for stdin")
+	fs.StringVar(&opts.digest, "digest", os.Getenv(enum.SETUP_CA_CERT_DIGEST), "Expected hex encoded SHA-256 digest of the CA certificates")
+	fs.StringVar(&opts.bundleName, "bundle-name", os.Getenv(enum.SETUP_CA_CERT_BUNDLE_NAME), "Bundle name recorded in the trust manifest")
+	fs.StringVar(&opts.outputDir, "output-dir", enum.SETUP_WORKSPACE, "Directory to write the trust store to")
+	fs.StringVar(&formats, "format", strings.Join([]string{formatStore, formatManifest}, ","), "Comma separated output formats: store, bundle, manifest, hashes")
+	fs.StringVar(&opts.mode, "mode", modeMerge, "merge adds the CA certificates to the ones of the image, replace trusts them only")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "Print the files that would be written without writing them")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		return nil, parseError(err)
 	}
+	opts.digestRequired = opts.dataFile == ""
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "data" {
+			opts.digestRequired = false
+		}
+	})
 
-	logger.Println("Populate certificate...")
-	_, err = file.WriteString(os.Getenv(enum.SETUP_CA_CERT_DATA))
-	if err != nil {
-		log.Fatal(err)
+	for _, f := range strings.Split(formats, ",") {
+		switch f = strings.TrimSpace(f); f {
+		case formatStore, formatBundle, formatManifest, formatHashes:
+			opts.formats = append(opts.formats, f)
+		case "":
+		default:
+			return nil, parseError(fmt.Errorf("unknown format %q", f))
+		}
+	}
+	if len(opts.formats) == 0 {
+		return nil, parseError(errors.New("at least one format is required"))
 	}
+	if opts.mode != modeMerge && opts.mode != modeReplace {
+		return nil, parseError(fmt.Errorf("unknown mode %q", opts.mode))
+	}
+	if opts.outputDir == "" {
+		return nil, parseError(errors.New("output dir is required"))
+	}
+	return opts, nil
+}
 
-	err = file.Close()
-	if err != nil {
-		log.Fatal(err)
+func (o *options) hasFormat(format string) bool {
+	for _, f := range o.formats {
+		if f == format {
+			return true
+		}
 	}
+	return false
+}
 
-	logger.Println("Update CA certificates...")
-	cmd := exec.Command("update-ca-certificates", "--etccertsdir", tempCerts, "--localcertsdir", tempLocal)
-	err = cmd.Run()
+func run(logger *zap.SugaredLogger, opts *options) error {
+	data, err := readData(opts)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	logger.Info("Verify certificate digest...")
+	if err := VerifyDigest(data, opts.digest, opts.digestRequired); err != nil {
+		return verifyError(err)
 	}
 
-	logger.Println("Copying CA certificates...")
-	err = CopyDir(tempCerts, enum.SETUP_WORKSPACE)
+	certificates, err := certs.Parse([]byte(data))
 	if err != nil {
-		log.Fatal(err)
+		return parseError(err)
+	}
+	if len(certificates) == 0 {
+		return parseError(errors.New("no certificates found"))
 	}
 
-	logger.Println("Finished setting up CA certificates")
-}
+	// update-ca-certificates links to the certificates of local, so it has
+	// to outlive the copy.
+	local, err := ioutil.TempDir("", "local")
+	if err != nil {
+		return ioError(err)
+	}
+	defer os.RemoveAll(local)
 
-func CopyDir(src string, dest string) error {
-	var (
-		err  error
-		fds  []os.FileInfo
-		info os.FileInfo
-	)
+	staging, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		return ioError(err)
+	}
+	defer os.RemoveAll(staging)
 
-	if info, err = os.Stat(src); err != nil {
-		return err
+	if opts.hasFormat(formatStore) || opts.hasFormat(formatBundle) {
+		logger.Infow("Update CA certificates...", zap.String("mode", opts.mode))
+		if err := updateCaCertificates(staging, local, data, opts); err != nil {
+			return ioError(err)
+		}
 	}
 
-	if err = os.MkdirAll(dest, info.Mode()); err != nil {
-		return err
+	if opts.hasFormat(formatManifest) {
+		logger.Info("Writing trust manifest...")
+		if err := WriteManifest(filepath.Join(staging, certs.ManifestFile), opts.bundleName, data); err != nil {
+			return ioError(err)
+		}
 	}
 
-	if fds, err = ioutil.ReadDir(src); err != nil {
-		return err
+	if opts.hasFormat(formatHashes) {
+		logger.Info("Writing certificate hashes...")
+		if err := WriteHashes(filepath.Join(staging, certs.HashDir), data); err != nil {
+			return ioError(err)
+		}
 	}
 
-	for _, fd := range fds {
-		srcPath := path.Join(src, fd.Name())
-		destPath := path.Join(dest, fd.Name())
+	if opts.dryRun {
+		return printPlan(logger, staging, opts.outputDir)
+	}
 
-		if fd.IsDir() {
-			if err = CopyDir(srcPath, destPath); err != nil {
-				return err
-			}
-		} else {
-			if err = CopyFile(srcPath, destPath); err != nil {
-				return err
-			}
-		}
+	logger.Infow("Copying CA certificates...", zap.String("outputDir", opts.outputDir))
+	if err := WriteAtomic(staging, opts.outputDir); err != nil {
+		return ioError(err)
 	}
 
+	logger.Infow("Finished setting up CA certificates", zap.Int("certificates", len(certificates)))
 	return nil
 }
 
-func CopyFile(src, dest string) error {
+func readData(opts *options) (string, error) {
+	if opts.dataFile == "" {
+		if opts.data == "" {
+			return "", parseError(errors.New("no CA certificates given, set --data, --data-file or " + enum.SETUP_CA_CERT_DATA))
+		}
+		return opts.data, nil
+	}
+
 	var (
-		err      error
-		srcFile  *os.File
-		destFile *os.File
-		info     os.FileInfo
+		b   []byte
+		err error
 	)
+	if opts.dataFile == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(opts.dataFile)
+	}
+	if err != nil {
+		return "", ioError(err)
+	}
+	return string(b), nil
+}
 
-	if srcFile, err = os.Open(src); err != nil {
+// updateCaCertificates generates the trust store into dest from the certificates
+// of the image and data, which is stored in local. In bundle format everything
+// but the bundle file is removed afterwards.
+func updateCaCertificates(dest, local, data string, opts *options) error {
+	if err := ioutil.WriteFile(filepath.Join(local, "cert-injection-webhook.crt"), []byte(data), 0644); err != nil {
 		return err
 	}
-	defer srcFile.Close()
 
-	if destFile, err = os.Create(dest); err != nil {
-		return err
+	args := []string{"--etccertsdir", dest, "--localcertsdir", local}
+	if opts.mode == modeReplace {
+		// An empty configuration skips every CA certificate of the image.
+		certsConf := filepath.Join(local, "ca-certificates.conf")
+		if err := ioutil.WriteFile(certsConf, nil, 0644); err != nil {
+			return err
+		}
+		args = append(args, "--certsconf", certsConf)
 	}
-	defer destFile.Close()
 
-	if _, err = io.Copy(destFile, srcFile); err != nil {
-		return err
+	cmd := exec.Command("update-ca-certificates", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("update-ca-certificates: %w: %s", err, strings.TrimSpace(string(out)))
 	}
 
-	if info, err = os.Stat(src); err != nil {
+	if opts.hasFormat(formatStore) {
+		return nil
+	}
+	fds, err := ioutil.ReadDir(dest)
+	if err != nil {
 		return err
 	}
+	for _, fd := range fds {
+		if fd.Name() != bundleFile {
+			if err := os.RemoveAll(filepath.Join(dest, fd.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printPlan logs every file of the staging directory as it would be written to dest.
+func printPlan(logger *zap.SugaredLogger, staging, dest string) error {
+	err := filepath.Walk(staging, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil {
+			return err
+		}
+		logger.Infow("Would write", zap.String("path", filepath.Join(dest, rel)), zap.Int64("size", info.Size()), zap.String("mode", info.Mode().String()))
+		return nil
+	})
+	if err != nil {
+		return ioError(err)
+	}
+	return nil
+}
 
-	return os.Chmod(dest, info.Mode())
+// VerifyDigest checks data against the expected hex encoded SHA-256 digest.
+// When required, a missing digest fails the check: the data may have been added
+// to the pod spec without going through the webhook. The webhook sets the digest
+// in the same pod spec as the data, so it catches a corrupted or truncated
+// variable, not tampering: whoever can edit the data can edit the digest too.
+func VerifyDigest(data, digest string, required bool) error {
+	if digest == "" {
+		if data == "" || !required {
+			return nil
+		}
+		return fmt.Errorf("%s is missing, refusing to trust unverified CA certificates", enum.SETUP_CA_CERT_DIGEST)
+	}
+	if actual := certs.Digest([]byte(data)); actual != digest {
+		return fmt.Errorf("%s digest mismatch: expected sha256:%s but got sha256:%s, the pod spec may have been modified after admission", enum.SETUP_CA_CERT_DATA, digest, actual)
+	}
+	return nil
 }
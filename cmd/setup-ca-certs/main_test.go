@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/zezaeoh/knurse/internal/certs"
+	"github.com/zezaeoh/knurse/internal/enum"
+)
+
+func TestParseFlags(t *testing.T) {
+	spec.Run(t, "parseFlags", testParseFlags)
+}
+
+func testParseFlags(t *testing.T, when spec.G, it spec.S) {
+	it("defaults to the environment set by the webhook", func() {
+		t.Setenv(enum.SETUP_CA_CERT_DATA, certificate)
+		t.Setenv(enum.SETUP_CA_CERT_DIGEST, "abc")
+		t.Setenv(enum.SETUP_CA_CERT_BUNDLE_NAME, "corp")
+
+		opts, err := parseFlags(nil)
+		require.NoError(t, err)
+		assert.Equal(t, &options{
+			data:           certificate,
+			digest:         "abc",
+			digestRequired: true,
+			bundleName:     "corp",
+			outputDir:      enum.SETUP_WORKSPACE,
+			formats:        []string{formatStore, formatManifest},
+			mode:           modeMerge,
+		}, opts)
+	})
+
+	it("parses the flags", func() {
+		opts, err := parseFlags([]string{
+			"--data-file", "-",
+			"--output-dir", "/out",
+			"--format", " bundle, hashes,",
+			"--mode", "replace",
+			"--dry-run",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "-", opts.dataFile)
+		assert.Equal(t, "/out", opts.outputDir)
+		assert.Equal(t, []string{formatBundle, formatHashes}, opts.formats)
+		assert.Equal(t, modeReplace, opts.mode)
+		assert.True(t, opts.dryRun)
+		assert.False(t, opts.digestRequired)
+	})
+
+	it("does not require a digest for the data given on the command line", func() {
+		t.Setenv(enum.SETUP_CA_CERT_DATA, "")
+
+		opts, err := parseFlags([]string{"--data", certificate})
+		require.NoError(t, err)
+		assert.False(t, opts.digestRequired)
+	})
+
+	for _, args := range [][]string{
+		{"--unknown"},
+		{"--format", "pem"},
+		{"--format", ","},
+		{"--mode", "append"},
+		{"--output-dir", ""},
+	} {
+		args := args
+		it("fails with a parse error on "+args[0]+" "+args[len(args)-1], func() {
+			_, err := parseFlags(args)
+			assert.Equal(t, exitParseError, exitCode(t, err))
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	spec.Run(t, "run", testRun)
+}
+
+func testRun(t *testing.T, when spec.G, it spec.S) {
+	var (
+		logger *zap.SugaredLogger
+		logs   *observer.ObservedLogs
+		opts   *options
+	)
+
+	it.Before(func() {
+		var core zapcore.Core
+		core, logs = observer.New(zap.InfoLevel)
+		logger = zap.New(core).Sugar()
+		opts = &options{
+			data:       certificate,
+			digest:     certs.Digest([]byte(certificate)),
+			bundleName: "corp",
+			outputDir:  filepath.Join(t.TempDir(), "out"),
+			formats:    []string{formatManifest, formatHashes},
+			mode:       modeMerge,
+			dryRun:     true,
+		}
+	})
+
+	when("dry-run", func() {
+		it("prints the files it would write without writing them", func() {
+			require.NoError(t, run(logger, opts))
+
+			var paths []interface{}
+			for _, entry := range logs.FilterMessage("Would write").All() {
+				paths = append(paths, entry.ContextMap()["path"])
+			}
+			assert.ElementsMatch(t, []interface{}{
+				filepath.Join(opts.outputDir, certs.ManifestFile),
+				filepath.Join(opts.outputDir, certs.HashDir, "1e189625.0"),
+			}, paths)
+
+			_, err := os.Stat(opts.outputDir)
+			assert.True(t, os.IsNotExist(err))
+		})
+
+		it("fails with a verify error on a digest mismatch", func() {
+			opts.digest = certs.Digest([]byte("other"))
+			assert.Equal(t, exitVerifyError, exitCode(t, run(logger, opts)))
+		})
+
+		it("fails with a verify error without the digest of the webhook data", func() {
+			opts.digest = ""
+			opts.digestRequired = true
+			assert.Equal(t, exitVerifyError, exitCode(t, run(logger, opts)))
+		})
+
+		it("accepts the data of the command line without a digest", func() {
+			opts.digest = ""
+			require.NoError(t, run(logger, opts))
+		})
+
+		it("fails with a parse error without certificates", func() {
+			opts.data = "not a certificate"
+			opts.digest = certs.Digest([]byte(opts.data))
+			assert.Equal(t, exitParseError, exitCode(t, run(logger, opts)))
+		})
+
+		it("fails with an io error on an unreadable data file", func() {
+			opts.dataFile = filepath.Join(t.TempDir(), "missing.pem")
+			assert.Equal(t, exitIOError, exitCode(t, run(logger, opts)))
+		})
+	})
+}
+
+func exitCode(t *testing.T, err error) int {
+	t.Helper()
+	var exitErr *exitError
+	require.True(t, errors.As(err, &exitErr), "expected an exit error, got %v", err)
+	return exitErr.code
+}
+
+func TestVerifyDigest(t *testing.T) {
+	spec.Run(t, "VerifyDigest", testVerifyDigest)
+}
+
+func testVerifyDigest(t *testing.T, when spec.G, it spec.S) {
+	it("accepts the data matching the digest", func() {
+		require.NoError(t, VerifyDigest(certificate, certs.Digest([]byte(certificate)), true))
+	})
+
+	it("rejects the data not matching the digest", func() {
+		err := VerifyDigest(certificate+"\n", certs.Digest([]byte(certificate)), false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "digest mismatch")
+	})
+
+	it("rejects the data without a required digest", func() {
+		err := VerifyDigest(certificate, "", true)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing")
+	})
+
+	it("accepts the data without an optional digest", func() {
+		require.NoError(t, VerifyDigest(certificate, "", false))
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/zezaeoh/knurse/internal/certs"
+)
+
+// WriteManifest writes a JSON description of the injected certificates to dest.
+func WriteManifest(dest, bundle, data string) error {
+	certificates, err := certs.Parse([]byte(data))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(certs.Manifest{
+		Bundle:       bundle,
+		Digest:       "sha256:" + certs.Digest([]byte(data)),
+		GeneratedAt:  time.Now().UTC(),
+		Certificates: certificates,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dest, append(b, '\n'), 0644)
+}
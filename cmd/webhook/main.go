@@ -2,16 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/zezaeoh/knurse/internal/bundle"
 	"github.com/zezaeoh/knurse/internal/config"
-	"github.com/zezaeoh/knurse/internal/webhook/cacerts"
+	"github.com/zezaeoh/knurse/internal/health"
+	"github.com/zezaeoh/knurse/internal/injection/kube/informers/core/v1/secret/replicated"
+	"github.com/zezaeoh/knurse/internal/injector"
+	"github.com/zezaeoh/knurse/internal/injector/cacerts"
+	"github.com/zezaeoh/knurse/internal/injector/mirror"
+	"github.com/zezaeoh/knurse/internal/injector/patches"
+	"github.com/zezaeoh/knurse/internal/injector/proxy"
+	"github.com/zezaeoh/knurse/internal/injector/pullsecrets"
+	"github.com/zezaeoh/knurse/internal/injector/sidecars"
+	"github.com/zezaeoh/knurse/internal/injector/timezone"
+	"github.com/zezaeoh/knurse/internal/webhook/admission"
+	"go.uber.org/zap"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
 	"knative.dev/pkg/webhook/certificates"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
 
+	"knative.dev/pkg/injection"
 	"knative.dev/pkg/injection/sharedmain"
 	"knative.dev/pkg/signals"
 	"knative.dev/pkg/webhook"
@@ -21,6 +38,7 @@ const (
 	defaultWebhookSecretName = "knurse-tls"
 	defaultServiceName       = "knurse"
 	defaultPort              = 8443
+	defaultInspectionPort    = 8080
 )
 
 // init initialize configs
@@ -44,26 +62,115 @@ func main() {
 		port = defaultPort
 	}
 
+	inspectionPort, err := strconv.Atoi(os.Getenv("KNURSE_INSPECTION_PORT"))
+	if err != nil {
+		inspectionPort = defaultInspectionPort
+	}
+
 	ctx := webhook.WithOptions(signals.NewContext(), webhook.Options{
 		ServiceName: sn,
 		Port:        port,
 		SecretName:  wsn,
 	})
 
-	sharedmain.MainWithContext(ctx, "knurse",
-		certificates.NewController,
-		caCertsAdmissionController,
-	)
-}
+	disableHighAvailability := flag.Bool("disable-ha", false,
+		"Whether to disable high-availability functionality for this component.")
+
+	// The config decides how many admission controllers are run, so flags are
+	// parsed before the controllers are constructed.
+	restCfg := injection.ParseAndGetRESTConfigOrDie()
+	if *disableHighAvailability {
+		ctx = sharedmain.WithHADisabled(ctx)
+	}
 
-func caCertsAdmissionController(ctx context.Context, _ configmap.Watcher) *controller.Impl {
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Fail to get config: %s", err)
 	}
-	return cacerts.NewAdmissionController(
-		ctx,
-		cfg,
-		nil,
-	)
+	bundles := bundle.NewStore(cfg.PublicKey)
+	tracker := health.NewTracker()
+
+	// Injectors available to the config, add new ones here.
+	registry := injector.NewRegistry()
+	registry.Register(config.CaCertsInjector, cacerts.NewFactory(bundles))
+	registry.Register(config.ProxyInjector, proxy.NewFactory())
+	registry.Register(config.TimezoneInjector, timezone.NewFactory())
+	registry.Register(config.MirrorInjector, mirror.NewFactory())
+	registry.Register(config.PullSecretsInjector, pullsecrets.NewFactory())
+	registry.Register(config.SidecarsInjector, sidecars.NewFactory())
+	registry.Register(config.PatchesInjector, patches.NewFactory())
+
+	ctors := []injection.ControllerConstructor{
+		certificates.NewController,
+		func(ctx context.Context, _ configmap.Watcher) *controller.Impl {
+			return cacerts.NewBundleController(ctx, cfg, bundles)
+		},
+	}
+	// Replicating the pull secrets needs access to the Secrets of every
+	// namespace, which is only granted when the injector is enabled.
+	if cfg.InjectorEnabled(config.PullSecretsInjector) {
+		injection.Default.RegisterInformer(replicated.WithInformer)
+		ctors = append(ctors, func(ctx context.Context, _ configmap.Watcher) *controller.Impl {
+			return pullsecrets.NewReplicationController(ctx, cfg)
+		})
+	}
+	for _, path := range webhookPaths(cfg) {
+		ctors = append(ctors, admissionController(cfg, path, registry, tracker))
+	}
+
+	go serveInspection(ctx, fmt.Sprintf(":%d", inspectionPort), bundles, tracker)
+	sharedmain.MainWithConfig(ctx, "knurse", restCfg, ctors...)
+}
+
+// webhookPaths returns the distinct paths of the configured webhook entries.
+func webhookPaths(cfg *config.Config) []string {
+	var paths []string
+	seen := map[string]struct{}{}
+	for _, entry := range cfg.WebhookEntries() {
+		if _, ok := seen[entry.Path]; ok {
+			continue
+		}
+		seen[entry.Path] = struct{}{}
+		paths = append(paths, entry.Path)
+	}
+	return paths
+}
+
+func admissionController(cfg *config.Config, path string, registry *injector.Registry, tracker *health.Tracker) injection.ControllerConstructor {
+	return func(ctx context.Context, _ configmap.Watcher) *controller.Impl {
+		return admission.NewAdmissionController(
+			ctx,
+			cfg,
+			path,
+			registry,
+			tracker,
+			nil,
+		)
+	}
+}
+
+// serveInspection serves the read-only bundle inspection API, the status of the
+// webhook configurations and the readiness on addr until ctx is done. It is plain HTTP
+// without authentication, the bundles only hold public certificates.
+func serveInspection(ctx context.Context, addr string, bundles *bundle.Store, tracker *health.Tracker) {
+	logger := logging.FromContext(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", bundle.Handler(bundles))
+	mux.Handle("/status", health.StatusHandler(tracker))
+	mux.Handle("/readyz", health.ReadyHandler(tracker))
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.Infof("Serving bundle inspection API on %s", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Errorw("Bundle inspection API failed", zap.Error(err))
+	}
 }
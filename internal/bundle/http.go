@@ -0,0 +1,40 @@
+package bundle
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Handler serves the active bundles read-only:
+//
+//	GET /bundles      bundles with their certificates as JSON
+//	GET /bundles.pem  the PEM data injected into pods
+func Handler(store *Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bundles", func(w http.ResponseWriter, r *http.Request) {
+		if !allowed(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Bundles []Bundle `json:"bundles"`
+		}{store.Bundles()})
+	})
+	mux.HandleFunc("/bundles.pem", func(w http.ResponseWriter, r *http.Request) {
+		if !allowed(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		_, _ = w.Write([]byte(store.Data()))
+	})
+	return mux
+}
+
+func allowed(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package bundle
+
+import (
+	"crypto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zezaeoh/knurse/internal/certs"
+	"github.com/zezaeoh/knurse/internal/config"
+)
+
+type Source string
+
+const (
+	SourceConfig    Source = "config"
+	SourceSecret    Source = "secret"
+	SourceConfigMap Source = "configmap"
+)
+
+// Bundle is a CA certs bundle loaded from one source.
+type Bundle struct {
+	Name         string              `json:"name"`
+	Source       Source              `json:"source"`
+	Digest       string              `json:"digest,omitempty"`
+	LoadedAt     *time.Time          `json:"loadedAt,omitempty"`
+	LastError    string              `json:"lastError,omitempty"`
+	Certificates []certs.Certificate `json:"certificates"`
+
+	data string
+}
+
+// Store holds the active CA certs bundles in the order they were registered.
+// A bundle which fails to reload keeps its previously loaded data, unless its
+// source is gone.
+type Store struct {
+	publicKey crypto.PublicKey
+
+	mu      sync.RWMutex
+	bundles []*Bundle
+}
+
+// NewStore returns an empty store verifying bundle signatures with publicKey, if set.
+func NewStore(publicKey crypto.PublicKey) *Store {
+	return &Store{publicKey: publicKey}
+}
+
+// Register adds a bundle without data, so that it keeps its position once loaded.
+func (s *Store) Register(name string, source Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(name, source)
+}
+
+// Set verifies and parses data, and makes it the active data of the named bundle.
+func (s *Store) Set(name string, source Source, data, signature string) error {
+	err := s.set(name, source, data, signature)
+	if err != nil {
+		s.SetError(name, source, err)
+	}
+	return err
+}
+
+func (s *Store) set(name string, source Source, data, signature string) error {
+	if s.publicKey != nil {
+		if err := config.VerifySignature(s.publicKey, []byte(data), signature); err != nil {
+			return err
+		}
+	}
+	certificates, err := certs.Parse([]byte(data))
+	if err != nil {
+		return err
+	}
+	if data != "" && len(certificates) == 0 {
+		return errors.New("no certificates found")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	b := s.get(name, source)
+	b.data = data
+	b.Digest = certs.Digest([]byte(data))
+	b.Certificates = certificates
+	b.LoadedAt = &now
+	b.LastError = ""
+	return nil
+}
+
+// SetError records a failed reload of the named bundle.
+func (s *Store) SetError(name string, source Source, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(name, source).LastError = err.Error()
+}
+
+// Clear removes the data of the named bundle, whose source is gone, and records err.
+func (s *Store) Clear(name string, source Source, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(name, source)
+	b.data = ""
+	b.Digest = ""
+	b.Certificates = nil
+	b.LoadedAt = nil
+	b.LastError = err.Error()
+}
+
+// Data returns the concatenated PEM data of all loaded bundles.
+func (s *Store) Data() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var data []string
+	for _, b := range s.bundles {
+		if d := strings.TrimSpace(b.data); d != "" {
+			data = append(data, d)
+		}
+	}
+	return strings.Join(data, "\n")
+}
+
+// Bundles returns a snapshot of all bundles.
+func (s *Store) Bundles() []Bundle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bundles := make([]Bundle, 0, len(s.bundles))
+	for _, b := range s.bundles {
+		bundles = append(bundles, *b)
+	}
+	return bundles
+}
+
+func (s *Store) get(name string, source Source) *Bundle {
+	for _, b := range s.bundles {
+		if b.Name == name {
+			return b
+		}
+	}
+	b := &Bundle{Name: name, Source: source}
+	s.bundles = append(s.bundles, b)
+	return b
+}
@@ -0,0 +1,106 @@
+package bundle
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zezaeoh/knurse/internal/certs"
+)
+
+const certificate = `-----BEGIN CERTIFICATE-----
+MIIBbjCCAROgAwIBAgIQE6ttkwhxiyWXdGyCYeXx2TAKBggqhkjOPQQDAjAVMRMw
+EQYDVQQDEwp6ZXphZW9oLmlvMB4XDTIyMDMwMTExMDMxM1oXDTMyMDIyNzExMDMx
+M1owFTETMBEGA1UEAxMKemV6YWVvaC5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEH
+A0IABHX/JsHeUP4N3nqPrvxomMfEAZuVNZ4gqUxkYfZ4zBeInce/l0VJ3zs6T1UF
+CCrfz4Ikh808Hqn0WOkuuTrjAfqjRTBDMA4GA1UdDwEB/wQEAwIBBjASBgNVHRMB
+Af8ECDAGAQH/AgEBMB0GA1UdDgQWBBRZCI0gAEYflEredZJdcb4g8TaCSzAKBggq
+hkjOPQQDAgNJADBGAiEA6r77RFykldPNKKIzyazuDjQltBQpP5FXJH8u3jDx3tYC
+IQDo8vaYB9ySwxkM4aQvEZVvOUZf/uYVkwenThmIxDbw8w==
+-----END CERTIFICATE-----`
+
+func TestStore(t *testing.T) {
+	spec.Run(t, "Store", testStore)
+}
+
+func testStore(t *testing.T, when spec.G, it spec.S) {
+	var store *Store
+
+	it.Before(func() {
+		store = NewStore(nil)
+	})
+
+	when("#Set", func() {
+		it("makes the data active", func() {
+			require.NoError(t, store.Set("config", SourceConfig, certificate, ""))
+
+			assert.Equal(t, certificate, store.Data())
+			bundles := store.Bundles()
+			require.Len(t, bundles, 1)
+			assert.Equal(t, certs.Digest([]byte(certificate)), bundles[0].Digest)
+			assert.Len(t, bundles[0].Certificates, 1)
+			assert.NotNil(t, bundles[0].LoadedAt)
+			assert.Empty(t, bundles[0].LastError)
+		})
+
+		it("keeps the previous data of invalid data", func() {
+			require.NoError(t, store.Set("config", SourceConfig, certificate, ""))
+			require.Error(t, store.Set("config", SourceConfig, "not a certificate", ""))
+
+			assert.Equal(t, certificate, store.Data())
+			assert.Equal(t, "no certificates found", store.Bundles()[0].LastError)
+		})
+
+		it("clears the last error once loaded", func() {
+			store.SetError("config", SourceConfig, errors.New("failed"))
+			require.NoError(t, store.Set("config", SourceConfig, certificate, ""))
+
+			assert.Empty(t, store.Bundles()[0].LastError)
+		})
+	})
+
+	when("#SetError", func() {
+		it("records the error and keeps the data", func() {
+			require.NoError(t, store.Set("secret/ca/ca.crt", SourceSecret, certificate, ""))
+			store.SetError("secret/ca/ca.crt", SourceSecret, errors.New("failed"))
+
+			assert.Equal(t, certificate, store.Data())
+			assert.Equal(t, "failed", store.Bundles()[0].LastError)
+		})
+	})
+
+	when("#Clear", func() {
+		it("removes the data and records the error", func() {
+			require.NoError(t, store.Set("config", SourceConfig, certificate, ""))
+			require.NoError(t, store.Set("secret/ca/ca.crt", SourceSecret, certificate, ""))
+			store.Clear("secret/ca/ca.crt", SourceSecret, errors.New("not found"))
+
+			assert.Equal(t, certificate, store.Data())
+			cleared := store.Bundles()[1]
+			assert.Empty(t, cleared.Digest)
+			assert.Empty(t, cleared.Certificates)
+			assert.Nil(t, cleared.LoadedAt)
+			assert.Equal(t, "not found", cleared.LastError)
+		})
+	})
+
+	when("#Data", func() {
+		it("concatenates the bundles in the order they were registered", func() {
+			other := "# other\n" + certificate
+			store.Register("secret/ca/ca.crt", SourceSecret)
+			store.Register("configmap/ca/ca.crt", SourceConfigMap)
+			require.NoError(t, store.Set("configmap/ca/ca.crt", SourceConfigMap, other, ""))
+			require.NoError(t, store.Set("secret/ca/ca.crt", SourceSecret, certificate, ""))
+
+			assert.Equal(t, certificate+"\n"+other, store.Data())
+			var names []string
+			for _, b := range store.Bundles() {
+				names = append(names, b.Name)
+			}
+			assert.Equal(t, []string{"secret/ca/ca.crt", "configmap/ca/ca.crt"}, names)
+		})
+	})
+}
@@ -0,0 +1,64 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ManifestFile is the name of the trust manifest written next to the bundle.
+const ManifestFile = "knurse-manifest.json"
+
+// Certificate describes a single certificate of a CA certs bundle.
+type Certificate struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+// Manifest describes the certificates injected by knurse.
+type Manifest struct {
+	Bundle       string        `json:"bundle"`
+	Digest       string        `json:"digest"`
+	GeneratedAt  time.Time     `json:"generatedAt"`
+	Certificates []Certificate `json:"certificates"`
+}
+
+// Digest returns the hex encoded SHA-256 digest of a bundle.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Parse decodes every certificate of a PEM encoded bundle.
+// Non certificate PEM blocks are ignored.
+func Parse(data []byte) ([]Certificate, error) {
+	var certificates []Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse certificate #%d", len(certificates)+1)
+		}
+		certificates = append(certificates, Certificate{
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			Fingerprint: Digest(cert.Raw),
+			NotAfter:    cert.NotAfter,
+		})
+	}
+	return certificates, nil
+}
@@ -0,0 +1,73 @@
+package certs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bundle = `-----BEGIN CERTIFICATE-----
+MIIBbjCCAROgAwIBAgIQE6ttkwhxiyWXdGyCYeXx2TAKBggqhkjOPQQDAjAVMRMw
+EQYDVQQDEwp6ZXphZW9oLmlvMB4XDTIyMDMwMTExMDMxM1oXDTMyMDIyNzExMDMx
+M1owFTETMBEGA1UEAxMKemV6YWVvaC5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEH
+A0IABHX/JsHeUP4N3nqPrvxomMfEAZuVNZ4gqUxkYfZ4zBeInce/l0VJ3zs6T1UF
+CCrfz4Ikh808Hqn0WOkuuTrjAfqjRTBDMA4GA1UdDwEB/wQEAwIBBjASBgNVHRMB
+Af8ECDAGAQH/AgEBMB0GA1UdDgQWBBRZCI0gAEYflEredZJdcb4g8TaCSzAKBggq
+hkjOPQQDAgNJADBGAiEA6r77RFykldPNKKIzyazuDjQltBQpP5FXJH8u3jDx3tYC
+IQDo8vaYB9ySwxkM4aQvEZVvOUZf/uYVkwenThmIxDbw8w==
+-----END CERTIFICATE-----`
+
+// spacedBundle holds a certificate of subject "C=KR, O=Zezaeoh  Corp , CN=Root   CA One".
+const spacedBundle = `-----BEGIN CERTIFICATE-----
+MIIB0jCCAXegAwIBAgIUQeXkfeS5doOxDRlc5OoJyWhRqMwwCgYIKoZIzj0EAwIw
+PjELMAkGA1UEBhMCS1IxFzAVBgNVBAoMDlplemFlb2ggIENvcnAgMRYwFAYDVQQD
+DA1Sb290ICAgQ0EgT25lMB4XDTI2MTAxOTEzMTAyMVoXDTI2MTAyMDEzMTAyMVow
+PjELMAkGA1UEBhMCS1IxFzAVBgNVBAoMDlplemFlb2ggIENvcnAgMRYwFAYDVQQD
+DA1Sb290ICAgQ0EgT25lMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE3WoNoh5g
+BUjYExmFeDQXx5J5eFVARIzciGnt172MYN3htlaLlG6/ddCXLRIsjD4pKqPVpLU+
+d1QG60NoAxldXaNTMFEwHQYDVR0OBBYEFMmcQHq8mQqfbPOgpj+nKcV/kUegMB8G
+A1UdIwQYMBaAFMmcQHq8mQqfbPOgpj+nKcV/kUegMA8GA1UdEwEB/wQFMAMBAf8w
+CgYIKoZIzj0EAwIDSQAwRgIhAKMv8Yq6S6HUsAK46SwhToGWlkzsL4V+M3iBSZeq
+CEXTAiEA8bOOn565OSmTCzTjW1HDc7uCXVx2x/l0oSMsVGlc90c=
+-----END CERTIFICATE-----`
+
+func TestCerts(t *testing.T) {
+	spec.Run(t, "Certs", testCerts)
+}
+
+func testCerts(t *testing.T, when spec.G, it spec.S) {
+	when("#Parse", func() {
+		it("describes every certificate of the bundle", func() {
+			certificates, err := Parse([]byte(bundle + "\n" + bundle))
+			require.NoError(t, err)
+			require.Len(t, certificates, 2)
+
+			assert.Equal(t, "CN=zezaeoh.io", certificates[0].Subject)
+			assert.Equal(t, "CN=zezaeoh.io", certificates[0].Issuer)
+			assert.Len(t, certificates[0].Fingerprint, 64)
+			assert.Equal(t, time.Date(2032, 2, 27, 11, 3, 13, 0, time.UTC), certificates[0].NotAfter)
+		})
+
+		it("fails on malformed certificates", func() {
+			_, err := Parse([]byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----"))
+			assert.Error(t, err)
+		})
+	})
+
+	when("#HashFiles", func() {
+		it("names the certificates by their OpenSSL subject hash", func() {
+			names, err := HashFiles([]byte(bundle + "\n" + spacedBundle + "\n" + bundle))
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"1e189625.0", "3e027a9e.0", "1e189625.1"}, names)
+		})
+
+		it("fails on malformed certificates", func() {
+			_, err := HashFiles([]byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----"))
+			assert.Error(t, err)
+		})
+	})
+}
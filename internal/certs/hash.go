@@ -0,0 +1,156 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// HashDir is the directory of the trust store holding the hash files written
+// by setup-ca-certs.
+const HashDir = "knurse-hashes"
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type relativeDistinguishedNameSET []attributeTypeAndValue
+
+// SubjectHash returns the hash OpenSSL looks certificates up by in hashed
+// directories, as printed by `openssl x509 -subject_hash`.
+func SubjectHash(cert *x509.Certificate) (string, error) {
+	var rdns []relativeDistinguishedNameSET
+	if rest, err := asn1.Unmarshal(cert.RawSubject, &rdns); err != nil {
+		return "", errors.Wrap(err, "failed to parse the subject")
+	} else if len(rest) > 0 {
+		return "", errors.New("trailing data after the subject")
+	}
+
+	// The canonical encoding is the concatenation of the DER encoded RDN sets
+	// with canonical string values, without the enclosing sequence.
+	var canonical []byte
+	for _, rdn := range rdns {
+		var entries [][]byte
+		for _, atv := range rdn {
+			if value, ok := canonicalString(atv.Value); ok {
+				atv.Value = asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: value}
+			}
+			b, err := asn1.Marshal(atv)
+			if err != nil {
+				return "", err
+			}
+			entries = append(entries, b)
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i], entries[j]) < 0 })
+		set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(entries, nil)})
+		if err != nil {
+			return "", err
+		}
+		canonical = append(canonical, set...)
+	}
+
+	sum := sha1.Sum(canonical)
+	return fmt.Sprintf("%08x", binary.LittleEndian.Uint32(sum[:4])), nil
+}
+
+// canonicalString converts a string value to UTF-8, trims its whitespace,
+// collapses the inner runs of whitespace into a single space and lowercases
+// its ASCII letters, as OpenSSL does. ok is false for non string values.
+func canonicalString(v asn1.RawValue) ([]byte, bool) {
+	if v.Class != asn1.ClassUniversal {
+		return nil, false
+	}
+
+	var s []byte
+	switch v.Tag {
+	case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String, 26 /* VisibleString */ :
+		s = v.Bytes
+	case asn1.TagT61String:
+		// OpenSSL reads T61String as Latin-1.
+		runes := make([]rune, len(v.Bytes))
+		for i, b := range v.Bytes {
+			runes[i] = rune(b)
+		}
+		s = []byte(string(runes))
+	case 30 /* BMPString */ :
+		units := make([]uint16, len(v.Bytes)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(v.Bytes[2*i:])
+		}
+		s = []byte(string(utf16.Decode(units)))
+	case 28 /* UniversalString */ :
+		runes := make([]rune, len(v.Bytes)/4)
+		for i := range runes {
+			runes[i] = rune(binary.BigEndian.Uint32(v.Bytes[4*i:]))
+		}
+		s = []byte(string(runes))
+	default:
+		return nil, false
+	}
+
+	isSpace := func(b byte) bool { return strings.IndexByte(" \t\n\v\f\r", b) >= 0 }
+	for len(s) > 0 && isSpace(s[0]) {
+		s = s[1:]
+	}
+	for len(s) > 0 && isSpace(s[len(s)-1]) {
+		s = s[:len(s)-1]
+	}
+
+	canonical := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b >= 0x80:
+			canonical = append(canonical, b)
+		case isSpace(b):
+			canonical = append(canonical, ' ')
+			for i+1 < len(s) && isSpace(s[i+1]) {
+				i++
+			}
+		case 'A' <= b && b <= 'Z':
+			canonical = append(canonical, b+'a'-'A')
+		default:
+			canonical = append(canonical, b)
+		}
+	}
+	return canonical, true
+}
+
+// HashFiles returns the names of the hash files of the certificates of a PEM
+// encoded bundle, in order, e.g. 9d66eef0.0. Certificates with the same
+// subject hash get increasing suffixes.
+func HashFiles(data []byte) ([]string, error) {
+	var names []string
+	counts := map[string]int{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse certificate #%d", len(names)+1)
+		}
+		hash, err := SubjectHash(cert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to hash certificate #%d", len(names)+1)
+		}
+		names = append(names, fmt.Sprintf("%s.%d", hash, counts[hash]))
+		counts[hash]++
+	}
+	return names, nil
+}
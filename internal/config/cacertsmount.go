@@ -0,0 +1,62 @@
+package config
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Modes of CaCertsMount.
+const (
+	// MountDirectory mounts the trust store over the directory of the image.
+	MountDirectory = "directory"
+	// MountFiles mounts single files of the trust store by subPath, leaving
+	// the rest of the directory of the image visible.
+	MountFiles = "files"
+)
+
+// DefaultMountFiles are the files mounted in files mode when none are set.
+var DefaultMountFiles = []string{"ca-certificates.crt"}
+
+// CaCertsMount configures how the CA certs injector mounts the trust store
+// into the containers.
+type CaCertsMount struct {
+	// Mode is directory or files, defaults to directory.
+	Mode string `yaml:"mode"`
+	// Files are the files of the trust store mounted in files mode, defaults
+	// to DefaultMountFiles.
+	Files []string `yaml:"files"`
+	// HashLinks additionally mounts every injected certificate under its
+	// OpenSSL subject hash in files mode, e.g. 9d66eef0.0. The suffixes start at
+	// 0, as OpenSSL stops looking up a hash at the first missing suffix: a link
+	// of the image with the same name, i.e. one of its own certificates with the
+	// same subject hash, is hidden by the mounted one.
+	HashLinks bool `yaml:"hashLinks"`
+}
+
+// MountedFiles returns the files mounted in files mode.
+func (m CaCertsMount) MountedFiles() []string {
+	if len(m.Files) == 0 {
+		return DefaultMountFiles
+	}
+	return m.Files
+}
+
+func validateCaCertsMount(m CaCertsMount) error {
+	switch m.Mode {
+	case "", MountDirectory:
+		if len(m.Files) > 0 || m.HashLinks {
+			return errors.New("webhook.caCerts.mount: files and hashLinks require the files mode")
+		}
+	case MountFiles:
+	default:
+		return errors.Errorf("webhook.caCerts.mount.mode: unsupported value %q", m.Mode)
+	}
+	for i, file := range m.Files {
+		if file == "" || path.IsAbs(file) || path.Clean(file) != file || strings.HasPrefix(file, "..") {
+			return errors.Errorf("webhook.caCerts.mount.files[%d]: %q must be a relative path within the trust store", i, file)
+		}
+	}
+	return nil
+}
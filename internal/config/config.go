@@ -1,6 +1,8 @@
 package config
 
 import (
+	"crypto"
+
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 	"io/ioutil"
@@ -10,23 +12,94 @@ import (
 
 type Config struct {
 	ConfigDir string
+	// PublicKey verifies the signature of CA certs bundles when set.
+	PublicKey crypto.PublicKey `yaml:"-"`
+
+	// Injectors are the injectors mutating the admitted pods, in order.
+	Injectors []InjectorConfig `yaml:"injectors"`
 
 	Webhook struct {
 		ConfigName string `yaml:"configName"`
-		CaCerts    struct {
-			Name              string `yaml:"name"`
-			Path              string `yaml:"path"`
-			Data              string `yaml:"data"`
-			SetupCaCertsImage string `yaml:"setupCaCertsImage"`
-		} `yaml:"caCerts"`
+		// ServicePort is the port of the knurse Service the webhooks call.
+		ServicePort int32 `yaml:"servicePort"`
+		// OwnerClusterRole is the ClusterRole set as the owner of the
+		// MutatingWebhookConfigurations knurse creates, so that they are garbage
+		// collected along with it, e.g. on helm uninstall. Unowned when empty.
+		OwnerClusterRole string `yaml:"ownerClusterRole"`
+		// Webhooks lists the webhook entries knurse registers. When empty, a single
+		// entry is built from configName and the webhook settings of caCerts.
+		Webhooks []WebhookEntry `yaml:"webhooks"`
+		// ProtectedNamespaces are never mutated, nor is the knurse namespace.
+		// Defaults to the control-plane namespaces when unset.
+		ProtectedNamespaces []string `yaml:"protectedNamespaces"`
+		// ServiceAccountName is the service account knurse runs as. Its pods
+		// are never mutated.
+		ServiceAccountName string `yaml:"serviceAccountName"`
+		// Exemptions skip the injection into the pods they match.
+		Exemptions []Exemption `yaml:"exemptions"`
+		// MatchConditions must all evaluate to true for a pod to be mutated.
+		MatchConditions []MatchCondition `yaml:"matchConditions"`
+		// CaCerts configures the CA certs injector and the webhook entry built
+		// when webhooks is empty.
+		CaCerts CaCerts `yaml:"caCerts"`
+		// Proxy configures the proxy injector.
+		Proxy Proxy `yaml:"proxy"`
+		// Timezone configures the timezone injector.
+		Timezone Timezone `yaml:"timezone"`
+		// Mirror configures the mirror injector.
+		Mirror Mirror `yaml:"mirror"`
+		// PullSecrets configures the pullsecrets injector.
+		PullSecrets PullSecrets `yaml:"pullSecrets"`
+		// Sidecars configures the sidecars injector.
+		Sidecars Sidecars `yaml:"sidecars"`
+		// Patches configures the patches injector.
+		Patches Patches `yaml:"patches"`
+		// Resources sizes the containers injected by knurse.
+		Resources Resources `yaml:"resources"`
+		// SecurityContext hardens the containers injected by knurse.
+		SecurityContext SecurityContext `yaml:"securityContext"`
 	} `yaml:"webhook"`
 }
 
+// CaCerts configures the CA certs injector.
+type CaCerts struct {
+	MutatingWebhook `yaml:",inline"`
+
+	Name              string          `yaml:"name"`
+	Path              string          `yaml:"path"`
+	Data              string          `yaml:"data"`
+	Signature         string          `yaml:"signature"`
+	Sources           []CaCertsSource `yaml:"sources"`
+	SetupCaCertsImage string          `yaml:"setupCaCertsImage"`
+	// Mount configures how the trust store is mounted into the containers.
+	Mount CaCertsMount `yaml:"mount"`
+	// Conflicts resolves the conflicts with the volumes and mounts of the pods.
+	Conflicts CaCertsConflicts `yaml:"conflicts"`
+	// SkipContainers are the containers the CA certs are not mounted into.
+	SkipContainers CaCertsSkipContainers `yaml:"skipContainers"`
+}
+
+// CaCertsSource references a bundle stored in a Secret or ConfigMap of the knurse namespace.
+// Its signature is read from the "<key>.sig" entry of the same object.
+type CaCertsSource struct {
+	Secret    *KeyRef `yaml:"secret"`
+	ConfigMap *KeyRef `yaml:"configMap"`
+}
+
+// DefaultProtectedNamespaces are the control-plane namespaces protected when
+// webhook.protectedNamespaces is unset.
+var DefaultProtectedNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+type KeyRef struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
 func LoadConfig() (*Config, error) {
-	return loadConfig(configPath)
+	return loadConfig(configPath, publicKeyPath)
 }
 
-func loadConfig(configPath string) (*Config, error) {
+func loadConfig(configPath, publicKeyPath string) (*Config, error) {
 	configDir, err := filepath.Abs(configPath)
 	if err != nil {
 		return nil, err
@@ -54,6 +127,16 @@ func loadConfig(configPath string) (*Config, error) {
 		return nil, err
 	}
 
+	if publicKeyPath != "" {
+		cfg.PublicKey, err = LoadPublicKey(publicKeyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load ca certs public key")
+		}
+		if err = verifyConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+
 	cfg.ConfigDir = configDir
 	return cfg, nil
 }
@@ -63,21 +146,126 @@ func parseConfig(b []byte) (*Config, error) {
 	if err := yaml.Unmarshal(b, cfg); err != nil {
 		return nil, err
 	}
+	if cfg.Webhook.ProtectedNamespaces == nil {
+		cfg.Webhook.ProtectedNamespaces = DefaultProtectedNamespaces
+	}
+	if cfg.Webhook.CaCerts.SkipContainers.Names == nil {
+		cfg.Webhook.CaCerts.SkipContainers.Names = DefaultSkipContainerNames
+	}
+	if cfg.Webhook.CaCerts.SkipContainers.Images == nil {
+		cfg.Webhook.CaCerts.SkipContainers.Images = DefaultSkipContainerImages
+	}
 	return cfg, nil
 }
 
+// WebhookEntries returns the webhook entries knurse registers.
+func (cfg *Config) WebhookEntries() []WebhookEntry {
+	if len(cfg.Webhook.Webhooks) > 0 {
+		return cfg.Webhook.Webhooks
+	}
+	return []WebhookEntry{{
+		MutatingWebhook: cfg.Webhook.CaCerts.MutatingWebhook,
+		ConfigName:      cfg.Webhook.ConfigName,
+		Name:            cfg.Webhook.CaCerts.Name,
+		Path:            cfg.Webhook.CaCerts.Path,
+	}}
+}
+
 func validateConfig(cfg *Config) error {
-	if cfg.Webhook.ConfigName == "" {
-		return errors.New("webhook.configName: required but empty")
+	if len(cfg.Webhook.Webhooks) == 0 {
+		if cfg.Webhook.ConfigName == "" {
+			return errors.New("webhook.configName: required but empty")
+		}
+		if cfg.Webhook.CaCerts.Name == "" {
+			return errors.New("webhook.caCerts.name: required but empty")
+		}
+		if cfg.Webhook.CaCerts.Path == "" {
+			return errors.New("webhook.caCerts.path: required but empty")
+		}
+		if err := validateMutatingWebhook(cfg.Webhook.CaCerts.MutatingWebhook); err != nil {
+			return errors.Wrap(err, "webhook.caCerts")
+		}
+	}
+	if err := validateWebhookEntries(cfg.Webhook.Webhooks); err != nil {
+		return err
+	}
+	if p := cfg.Webhook.ServicePort; p < 0 || p > 65535 {
+		return errors.New("webhook.servicePort: must be a valid port")
+	}
+	for i, ns := range cfg.Webhook.ProtectedNamespaces {
+		if ns == "" {
+			return errors.Errorf("webhook.protectedNamespaces[%d]: required but empty", i)
+		}
 	}
-	if cfg.Webhook.CaCerts.Name == "" {
-		return errors.New("webhook.caCerts.name: required but empty")
+	if err := validateExemptions(cfg.Webhook.Exemptions); err != nil {
+		return err
 	}
-	if cfg.Webhook.CaCerts.Path == "" {
-		return errors.New("webhook.caCerts.path: required but empty")
+	if err := validateMatchConditions("webhook.matchConditions", cfg.Webhook.MatchConditions); err != nil {
+		return err
+	}
+	if err := validateInjectors(cfg.Injectors); err != nil {
+		return err
+	}
+	if err := validateProxy(cfg); err != nil {
+		return err
+	}
+	if err := validateTimezone(cfg); err != nil {
+		return err
+	}
+	if err := validateMirror(cfg); err != nil {
+		return err
+	}
+	if err := validatePullSecrets(cfg); err != nil {
+		return err
+	}
+	if err := validateSidecars(cfg); err != nil {
+		return err
+	}
+	if err := validatePatches(cfg); err != nil {
+		return err
+	}
+	if err := validateResources(cfg); err != nil {
+		return err
+	}
+	if err := validateSecurityContext(cfg); err != nil {
+		return err
 	}
 	if cfg.Webhook.CaCerts.SetupCaCertsImage == "" {
 		return errors.New("webhook.caCerts.setupCaCertsImage: required but empty")
 	}
+	for i, src := range cfg.Webhook.CaCerts.Sources {
+		if err := validateCaCertsSource(src); err != nil {
+			return errors.Wrapf(err, "webhook.caCerts.sources[%d]", i)
+		}
+	}
+	if err := validateCaCertsMount(cfg.Webhook.CaCerts.Mount); err != nil {
+		return err
+	}
+	if err := validateCaCertsConflicts(cfg.Webhook.CaCerts.Conflicts); err != nil {
+		return err
+	}
+	return validateCaCertsSkipContainers(cfg.Webhook.CaCerts.SkipContainers)
+}
+
+func validateCaCertsSource(src CaCertsSource) error {
+	ref := src.Secret
+	if src.Secret == nil && src.ConfigMap == nil || src.Secret != nil && src.ConfigMap != nil {
+		return errors.New("exactly one of secret or configMap is required")
+	} else if src.ConfigMap != nil {
+		ref = src.ConfigMap
+	}
+	if ref.Name == "" {
+		return errors.New("name: required but empty")
+	}
+	if ref.Key == "" {
+		return errors.New("key: required but empty")
+	}
+	return nil
+}
+
+func verifyConfig(cfg *Config) error {
+	if err := VerifySignature(cfg.PublicKey, []byte(cfg.Webhook.CaCerts.Data), cfg.Webhook.CaCerts.Signature); err != nil {
+		return errors.Wrap(err, "webhook.caCerts.signature")
+	}
 	return nil
 }
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Strategies resolving the conflicts of the CA certs injector with the pods.
+const (
+	// ConflictRename mounts the CA certs from a volume named uniquely.
+	ConflictRename = "rename"
+	// ConflictSkip leaves the conflicting container without the CA certs.
+	ConflictSkip = "skip"
+	// ConflictSubPath only mounts the bundle file into the conflicting container.
+	// It denies the pod when the conflicting volume is read-only, e.g. a
+	// ConfigMap or Secret, the bundle cannot be mounted into it.
+	ConflictSubPath = "subPath"
+	// ConflictDeny rejects the pod.
+	ConflictDeny = "deny"
+)
+
+// CaCertsConflicts configures how the CA certs injector handles the pods
+// already holding a volume named like its own, or containers already mounting
+// a volume at the trust store. Handled conflicts are returned as admission
+// warnings.
+type CaCertsConflicts struct {
+	// Volume is rename or deny, defaults to rename.
+	Volume string `yaml:"volume"`
+	// Mount is skip, subPath or deny, defaults to skip.
+	Mount string `yaml:"mount"`
+}
+
+func validateCaCertsConflicts(c CaCertsConflicts) error {
+	switch c.Volume {
+	case "", ConflictRename, ConflictDeny:
+	default:
+		return errors.Errorf("webhook.caCerts.conflicts.volume: unsupported value %q", c.Volume)
+	}
+	switch c.Mount {
+	case "", ConflictSkip, ConflictSubPath, ConflictDeny:
+	default:
+		return errors.Errorf("webhook.caCerts.conflicts.mount: unsupported value %q", c.Mount)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Exemption skips the injection into the pods it matches. An exemption matches
+// a pod when every one of its set fields matches, a field matches when any of
+// its values does.
+type Exemption struct {
+	Name string `yaml:"name"`
+	// Users are the usernames of the requests creating the pod.
+	Users []string `yaml:"users"`
+	// Groups are the groups of the user creating the pod.
+	Groups []string `yaml:"groups"`
+	// ServiceAccounts are the service accounts of the pod, as "namespace/name",
+	// or "name" for the service accounts of that name in any namespace.
+	ServiceAccounts []string `yaml:"serviceAccounts"`
+	// OwnerKinds are the kinds of the controller owner of the pod, as "Kind" or
+	// "group/Kind", e.g. "apps/DaemonSet" or "batch/Job".
+	OwnerKinds []string `yaml:"ownerKinds"`
+}
+
+func validateExemptions(exemptions []Exemption) error {
+	for i, e := range exemptions {
+		if len(e.Users) == 0 && len(e.Groups) == 0 && len(e.ServiceAccounts) == 0 && len(e.OwnerKinds) == 0 {
+			return errors.Errorf("webhook.exemptions[%d]: one of users, groups, serviceAccounts or ownerKinds is required", i)
+		}
+		for j, sa := range e.ServiceAccounts {
+			if sa == "" || strings.Count(sa, "/") > 1 || strings.HasPrefix(sa, "/") || strings.HasSuffix(sa, "/") {
+				return errors.Errorf("webhook.exemptions[%d].serviceAccounts[%d]: must be \"namespace/name\" or \"name\"", i, j)
+			}
+		}
+		for j, kind := range e.OwnerKinds {
+			if kind == "" || strings.Count(kind, "/") > 1 || strings.HasSuffix(kind, "/") {
+				return errors.Errorf("webhook.exemptions[%d].ownerKinds[%d]: must be \"Kind\" or \"group/Kind\"", i, j)
+			}
+		}
+	}
+	return nil
+}
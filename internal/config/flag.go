@@ -2,9 +2,13 @@ package config
 
 import "flag"
 
-var configPath string
+var (
+	configPath    string
+	publicKeyPath string
+)
 
 // InitFlags is for explicitly initializing the flags.
 func InitFlags(flagset *flag.FlagSet) {
 	flagset.StringVar(&configPath, "config", configPath, "Config file path to load")
+	flagset.StringVar(&publicKeyPath, "ca-certs-public-key", publicKeyPath, "Public key file to verify the ca certs bundle signature with")
 }
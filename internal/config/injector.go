@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// CaCertsInjector is the name of the injector of the CA certs bundle, the only
+// one enabled when no injector is configured.
+const CaCertsInjector = "cacerts"
+
+// InjectorConfig enables an injector. Injectors run in the order they are listed.
+type InjectorConfig struct {
+	Name string `yaml:"name"`
+	// MatchConditions must all evaluate to true for the injector to mutate a pod.
+	MatchConditions []MatchCondition `yaml:"matchConditions"`
+}
+
+// UnmarshalYAML rejects unknown fields: the injectors are configured under
+// webhook, e.g. webhook.proxy, not in their entry.
+func (ic *InjectorConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain InjectorConfig
+	if err := value.Decode((*plain)(ic)); err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(value.Content) && value.Kind == yaml.MappingNode; i += 2 {
+		switch key := value.Content[i].Value; key {
+		case "name", "matchConditions":
+		default:
+			return errors.Errorf("injector %q: unknown field %q, configure it under webhook", ic.Name, key)
+		}
+	}
+	return nil
+}
+
+// EnabledInjectors returns the injectors to run, in order.
+func (cfg *Config) EnabledInjectors() []InjectorConfig {
+	if len(cfg.Injectors) > 0 {
+		return cfg.Injectors
+	}
+	return []InjectorConfig{{Name: CaCertsInjector}}
+}
+
+// InjectorEnabled returns whether the injector name is enabled in the config.
+func (cfg *Config) InjectorEnabled(name string) bool {
+	for _, ic := range cfg.EnabledInjectors() {
+		if ic.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func validateInjectors(injectors []InjectorConfig) error {
+	names := map[string]struct{}{}
+	for i, ic := range injectors {
+		if ic.Name == "" {
+			return errors.Errorf("injectors[%d].name: required but empty", i)
+		}
+		if _, ok := names[ic.Name]; ok {
+			return errors.Errorf("injectors[%d].name: duplicate injector %q", i, ic.Name)
+		}
+		names[ic.Name] = struct{}{}
+		if err := validateMatchConditions(fmt.Sprintf("injectors[%d].matchConditions", i), ic.MatchConditions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestInjectorConfig(t *testing.T) {
+	spec.Run(t, "InjectorConfig", testInjectorConfig)
+}
+
+func testInjectorConfig(t *testing.T, when spec.G, it spec.S) {
+	when("#UnmarshalYAML", func() {
+		it("decodes the name and match conditions", func() {
+			var injectors []InjectorConfig
+			require.NoError(t, yaml.Unmarshal([]byte(`
+- name: proxy
+  matchConditions:
+    - name: opted-in
+      expression: namespaceLabels["proxy"] == "enabled"
+`), &injectors))
+
+			require.Len(t, injectors, 1)
+			assert.Equal(t, "proxy", injectors[0].Name)
+			assert.Equal(t, "opted-in", injectors[0].MatchConditions[0].Name)
+		})
+
+		it("rejects the settings of the injector in its entry", func() {
+			var injectors []InjectorConfig
+			err := yaml.Unmarshal([]byte(`
+- name: proxy
+  config:
+    httpProxy: http://proxy.corp:3128
+`), &injectors)
+
+			assert.EqualError(t, err, `injector "proxy": unknown field "config", configure it under webhook`)
+		})
+	})
+}
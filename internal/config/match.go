@@ -0,0 +1,117 @@
+package config
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// MatchCondition is a CEL expression deciding whether a pod is mutated. It is
+// evaluated with the variables:
+//
+//	object           the pod, as in its JSON representation
+//	namespaceLabels  the labels of the namespace of the pod
+//	userInfo         the user creating the pod: username, uid, groups and extra
+//
+// e.g. `object.spec.containers.exists(c, c.image.startsWith("registry.corp/"))`
+type MatchCondition struct {
+	Name       string `yaml:"name"`
+	Expression string `yaml:"expression"`
+
+	program cel.Program
+}
+
+// MatchVariables are the values of the variables of a MatchCondition.
+type MatchVariables struct {
+	Object          map[string]interface{}
+	NamespaceLabels map[string]string
+	UserInfo        map[string]interface{}
+}
+
+var matchEnv *cel.Env
+
+func init() {
+	var err error
+	matchEnv, err = cel.NewEnv(cel.Declarations(
+		decls.NewVar("object", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("namespaceLabels", decls.NewMapType(decls.String, decls.String)),
+		decls.NewVar("userInfo", decls.NewMapType(decls.String, decls.Dyn)),
+	))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// UnmarshalYAML compiles the expression of the condition.
+func (c *MatchCondition) UnmarshalYAML(value *yaml.Node) error {
+	type plain MatchCondition
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Expression == "" {
+		return nil
+	}
+
+	ast, issues := matchEnv.Compile(c.Expression)
+	if issues != nil && issues.Err() != nil {
+		return errors.Wrapf(issues.Err(), "match condition %q", c.Name)
+	}
+	if !proto.Equal(ast.ResultType(), decls.Bool) && !proto.Equal(ast.ResultType(), decls.Dyn) {
+		return errors.Errorf("match condition %q: must evaluate to a bool", c.Name)
+	}
+	program, err := matchEnv.Program(ast)
+	if err != nil {
+		return errors.Wrapf(err, "match condition %q", c.Name)
+	}
+	c.program = program
+	return nil
+}
+
+// Matches evaluates the condition against vars.
+func (c *MatchCondition) Matches(vars MatchVariables) (bool, error) {
+	if c.program == nil {
+		return false, errors.Errorf("match condition %q is not compiled", c.Name)
+	}
+
+	namespaceLabels := vars.NamespaceLabels
+	if namespaceLabels == nil {
+		namespaceLabels = map[string]string{}
+	}
+	userInfo := vars.UserInfo
+	if userInfo == nil {
+		userInfo = map[string]interface{}{}
+	}
+	out, _, err := c.program.Eval(map[string]interface{}{
+		"object":          vars.Object,
+		"namespaceLabels": namespaceLabels,
+		"userInfo":        userInfo,
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "match condition %q", c.Name)
+	}
+	matched, ok := out.(types.Bool)
+	if !ok {
+		return false, errors.Errorf("match condition %q: evaluated to %v, not a bool", c.Name, out.Type())
+	}
+	return bool(matched), nil
+}
+
+func validateMatchConditions(field string, conditions []MatchCondition) error {
+	names := map[string]struct{}{}
+	for i, c := range conditions {
+		if c.Name == "" {
+			return errors.Errorf("%s[%d].name: required but empty", field, i)
+		}
+		if _, ok := names[c.Name]; ok {
+			return errors.Errorf("%s[%d].name: duplicate name %q", field, i, c.Name)
+		}
+		names[c.Name] = struct{}{}
+		if c.Expression == "" {
+			return errors.Errorf("%s[%d].expression: required but empty", field, i)
+		}
+	}
+	return nil
+}
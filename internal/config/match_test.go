@@ -0,0 +1,103 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMatchCondition(t *testing.T) {
+	spec.Run(t, "MatchCondition", testMatchCondition)
+}
+
+func testMatchCondition(t *testing.T, when spec.G, it spec.S) {
+	parse := func(expression string) (*MatchCondition, error) {
+		var conditions []MatchCondition
+		err := yaml.Unmarshal([]byte("- name: some-condition\n  expression: '"+expression+"'\n"), &conditions)
+		if err != nil {
+			return nil, err
+		}
+		return &conditions[0], nil
+	}
+
+	pod := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{"example.com/inject": "true"},
+			"ownerReferences": []interface{}{
+				map[string]interface{}{"apiVersion": "batch/v1", "kind": "Job", "name": "some-job"},
+			},
+		},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": "registry.corp/app:1.0"},
+			},
+		},
+	}
+
+	when("#UnmarshalYAML", func() {
+		it("compiles the expression", func() {
+			condition, err := parse(`object.spec.containers.exists(c, c.image.startsWith("registry.corp/"))`)
+			require.NoError(t, err)
+			assert.NotNil(t, condition.program)
+		})
+
+		it("rejects invalid expressions", func() {
+			_, err := parse(`object.spec.containers.exists(c,`)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), `match condition "some-condition"`)
+		})
+
+		it("rejects expressions not evaluating to a bool", func() {
+			_, err := parse(`namespaceLabels["team"]`)
+			require.EqualError(t, err, `match condition "some-condition": must evaluate to a bool`)
+		})
+
+		it("rejects unknown variables", func() {
+			_, err := parse(`pod.spec.nodeName == ""`)
+			require.Error(t, err)
+		})
+	})
+
+	when("#Matches", func() {
+		it("evaluates the expression over the pod, namespace labels and user info", func() {
+			condition, err := parse(`object.spec.containers.exists(c, c.image.startsWith("registry.corp/")) && namespaceLabels["team"] == "payments" && "ci" in userInfo.groups`)
+			require.NoError(t, err)
+
+			matched, err := condition.Matches(MatchVariables{
+				Object:          pod,
+				NamespaceLabels: map[string]string{"team": "payments"},
+				UserInfo:        map[string]interface{}{"username": "someone", "groups": []string{"ci"}},
+			})
+			require.NoError(t, err)
+			assert.True(t, matched)
+
+			matched, err = condition.Matches(MatchVariables{
+				Object:          pod,
+				NamespaceLabels: map[string]string{"team": "search"},
+				UserInfo:        map[string]interface{}{"username": "someone", "groups": []string{"ci"}},
+			})
+			require.NoError(t, err)
+			assert.False(t, matched)
+		})
+
+		it("supports guarding optional fields", func() {
+			condition, err := parse(`has(object.metadata.annotations) && "example.com/inject" in object.metadata.annotations && !object.metadata.ownerReferences.exists(o, o.kind == "Job")`)
+			require.NoError(t, err)
+
+			matched, err := condition.Matches(MatchVariables{Object: pod})
+			require.NoError(t, err)
+			assert.False(t, matched)
+		})
+
+		it("returns evaluation errors", func() {
+			condition, err := parse(`object.spec.nodeName == "some-node"`)
+			require.NoError(t, err)
+
+			_, err = condition.Matches(MatchVariables{Object: pod})
+			require.Error(t, err)
+		})
+	})
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// MirrorInjector is the name of the injector rewriting image references to mirrors.
+const MirrorInjector = "mirror"
+
+// Mirror configures the mirror injector, rewriting the image references of the
+// containers, init containers and ephemeral containers.
+type Mirror struct {
+	Selection `yaml:",inline"`
+
+	// Rules rewrite the image references, fully qualified as docker.io/library/nginx:1.21
+	// beforehand. The first matching rule applies.
+	Rules []MirrorRule `yaml:"rules"`
+	// Allowlist lists the registries whose images are never rewritten.
+	Allowlist []string `yaml:"allowlist"`
+}
+
+// MirrorRule rewrites the image references starting with Prefix, or matching
+// Regex, with Replacement.
+type MirrorRule struct {
+	Prefix string `yaml:"prefix"`
+	Regex  string `yaml:"regex"`
+	// Replacement replaces the prefix, or the match of the regex, expanding
+	// \1 to its first submatch and so on. $ is not usable as the config goes
+	// through the expansion of environment variables.
+	Replacement string `yaml:"replacement"`
+
+	regexp   *regexp.Regexp
+	template string
+}
+
+var submatchRef = regexp.MustCompile(`\\(\d+)`)
+
+// UnmarshalYAML compiles the regex of the rule.
+func (r *MirrorRule) UnmarshalYAML(value *yaml.Node) error {
+	type plain MirrorRule
+	if err := value.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	if r.Regex == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return errors.Wrapf(err, "mirror rule %q", r.Regex)
+	}
+	r.regexp = re
+	r.template = submatchRef.ReplaceAllString(r.Replacement, "$${$1}")
+	return nil
+}
+
+// Rewrite returns the reference rewritten by the rule, and whether it applies.
+func (r *MirrorRule) Rewrite(ref string) (string, bool) {
+	if r.regexp != nil {
+		if !r.regexp.MatchString(ref) {
+			return ref, false
+		}
+		return r.regexp.ReplaceAllString(ref, r.template), true
+	}
+	if r.Prefix == "" || !strings.HasPrefix(ref, r.Prefix) {
+		return ref, false
+	}
+	return r.Replacement + ref[len(r.Prefix):], true
+}
+
+func validateMirror(cfg *Config) error {
+	m := cfg.Webhook.Mirror
+	if cfg.InjectorEnabled(MirrorInjector) && len(m.Rules) == 0 {
+		return errors.New("webhook.mirror.rules: required by the mirror injector")
+	}
+	for i, rule := range m.Rules {
+		if (rule.Prefix == "") == (rule.Regex == "") {
+			return errors.Errorf("webhook.mirror.rules[%d]: exactly one of prefix or regex is required", i)
+		}
+		if rule.Replacement == "" {
+			return errors.Errorf("webhook.mirror.rules[%d].replacement: required but empty", i)
+		}
+	}
+	for i, registry := range m.Allowlist {
+		if registry == "" {
+			return errors.Errorf("webhook.mirror.allowlist[%d]: required but empty", i)
+		}
+	}
+	return validateSelection("webhook.mirror", m.Selection)
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// PatchesInjector is the name of the injector of the patch policies.
+const PatchesInjector = "patches"
+
+// Patches configures the patches injector.
+type Patches struct {
+	Policies []PatchPolicy `yaml:"policies"`
+}
+
+// PatchPolicy patches the pods it selects. The string values of its patches
+// are Go templates executed over the pod and its namespace, as in their JSON
+// representation, e.g. {{ .Pod.metadata.name }},
+// {{ .Namespace.metadata.labels.team }}. Patches setting fields unknown to
+// the Kubernetes API version of knurse fail.
+type PatchPolicy struct {
+	Selection `yaml:",inline"`
+
+	Name string `yaml:"name"`
+	// ObjectSelector selects the pods by their labels, all of them when unset.
+	ObjectSelector *LabelSelector `yaml:"objectSelector"`
+	// JSONPatch is a list of JSON Patch operations. An operation with ifAbsent
+	// is skipped when its path exists already, a failing test operation skips
+	// the whole policy, including the operations before it.
+	JSONPatch yaml.Node `yaml:"jsonPatch"`
+	// StrategicMergePatch is a strategic merge patch of the pod, applied after
+	// the JSON Patch operations.
+	StrategicMergePatch yaml.Node `yaml:"strategicMergePatch"`
+}
+
+func validatePatches(cfg *Config) error {
+	policies := cfg.Webhook.Patches.Policies
+	if cfg.InjectorEnabled(PatchesInjector) && len(policies) == 0 {
+		return errors.New("webhook.patches.policies: required by the patches injector")
+	}
+	names := map[string]struct{}{}
+	for i, policy := range policies {
+		if policy.Name == "" {
+			return errors.Errorf("webhook.patches.policies[%d].name: required but empty", i)
+		}
+		if _, ok := names[policy.Name]; ok {
+			return errors.Errorf("webhook.patches.policies[%d].name: duplicate policy %q", i, policy.Name)
+		}
+		names[policy.Name] = struct{}{}
+		if policy.JSONPatch.Kind == 0 && policy.StrategicMergePatch.Kind == 0 {
+			return errors.Errorf("webhook.patches.policies[%d]: jsonPatch or strategicMergePatch is required", i)
+		}
+		if policy.JSONPatch.Kind != 0 && policy.JSONPatch.Kind != yaml.SequenceNode {
+			return errors.Errorf("webhook.patches.policies[%d].jsonPatch: must be a list of operations", i)
+		}
+		if policy.StrategicMergePatch.Kind != 0 && policy.StrategicMergePatch.Kind != yaml.MappingNode {
+			return errors.Errorf("webhook.patches.policies[%d].strategicMergePatch: must be an object", i)
+		}
+		field := fmt.Sprintf("webhook.patches.policies[%d]", i)
+		if err := validateLabelSelector(field+".objectSelector", policy.ObjectSelector); err != nil {
+			return err
+		}
+		if err := validateSelection(field, policy.Selection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"net"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// ProxyInjector is the name of the injector of the proxy environment variables.
+const ProxyInjector = "proxy"
+
+// DefaultClusterDomain is the DNS domain of the cluster when proxy.clusterDomain is unset.
+const DefaultClusterDomain = "cluster.local"
+
+// Proxy configures the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables the proxy
+// injector sets, in upper and lower case, on every container.
+type Proxy struct {
+	Selection `yaml:",inline"`
+
+	HTTPProxy  string `yaml:"httpProxy"`
+	HTTPSProxy string `yaml:"httpsProxy"`
+	// NoProxy lists the hosts, domains and CIDRs reached without the proxy.
+	// The service CIDRs and the .svc and cluster domain suffixes are added to it.
+	NoProxy []string `yaml:"noProxy"`
+	// ServiceCIDRs are the CIDRs of the cluster services, required by the proxy
+	// injector. The API server does not expose them, and without them the
+	// traffic to the service IPs would go through the proxy.
+	ServiceCIDRs []string `yaml:"serviceCIDRs"`
+	// ClusterDomain is the DNS domain of the cluster, defaults to cluster.local.
+	ClusterDomain string `yaml:"clusterDomain"`
+}
+
+func validateProxy(cfg *Config) error {
+	p := cfg.Webhook.Proxy
+	if cfg.InjectorEnabled(ProxyInjector) && p.HTTPProxy == "" && p.HTTPSProxy == "" {
+		return errors.New("webhook.proxy: httpProxy or httpsProxy is required by the proxy injector")
+	}
+	for field, proxy := range map[string]string{"httpProxy": p.HTTPProxy, "httpsProxy": p.HTTPSProxy} {
+		if proxy == "" {
+			continue
+		}
+		if u, err := url.Parse(proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.Errorf("webhook.proxy.%s: must be a URL", field)
+		}
+	}
+	if cfg.InjectorEnabled(ProxyInjector) && len(p.ServiceCIDRs) == 0 {
+		return errors.New("webhook.proxy.serviceCIDRs: required by the proxy injector, e.g. the --service-cluster-ip-range of the API server")
+	}
+	for i, cidr := range p.ServiceCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.Errorf("webhook.proxy.serviceCIDRs[%d]: must be a CIDR", i)
+		}
+	}
+	for i, host := range p.NoProxy {
+		if host == "" {
+			return errors.Errorf("webhook.proxy.noProxy[%d]: required but empty", i)
+		}
+	}
+	return validateSelection("webhook.proxy", p.Selection)
+}
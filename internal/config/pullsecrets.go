@@ -0,0 +1,39 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+// PullSecretsInjector is the name of the injector of the imagePullSecrets.
+const PullSecretsInjector = "pullsecrets"
+
+// PullSecrets configures the pullsecrets injector, appending imagePullSecrets
+// to the pods.
+type PullSecrets struct {
+	Selection `yaml:",inline"`
+
+	// Secrets are docker-config Secrets of the knurse namespace. They are
+	// replicated, under the same name, into the namespaces selected by the
+	// namespaceSelector, and appended to the imagePullSecrets of their pods.
+	// The annotation only opts pods out, the pods of the other namespaces
+	// would reference missing Secrets.
+	Secrets []string `yaml:"secrets"`
+}
+
+func validatePullSecrets(cfg *Config) error {
+	p := cfg.Webhook.PullSecrets
+	if cfg.InjectorEnabled(PullSecretsInjector) && len(p.Secrets) == 0 {
+		return errors.New("webhook.pullSecrets.secrets: required by the pullsecrets injector")
+	}
+	names := map[string]struct{}{}
+	for i, name := range p.Secrets {
+		if name == "" {
+			return errors.Errorf("webhook.pullSecrets.secrets[%d]: required but empty", i)
+		}
+		if _, ok := names[name]; ok {
+			return errors.Errorf("webhook.pullSecrets.secrets[%d]: duplicate secret %q", i, name)
+		}
+		names[name] = struct{}{}
+	}
+	return validateSelection("webhook.pullSecrets", p.Selection)
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Resources sizes the containers injected by knurse which set no resources
+// themselves. When neither requests nor limits are configured, knurse sizes
+// them from the LimitRanges and ResourceQuotas of the namespace of the pod.
+type Resources struct {
+	Requests map[corev1.ResourceName]string `yaml:"requests"`
+	Limits   map[corev1.ResourceName]string `yaml:"limits"`
+}
+
+// Configured returns whether requests or limits are configured.
+func (r Resources) Configured() bool {
+	return len(r.Requests) > 0 || len(r.Limits) > 0
+}
+
+// Requirements returns the configured requests and limits.
+func (r Resources) Requirements() (corev1.ResourceRequirements, error) {
+	requests, err := resourceList(r.Requests)
+	if err != nil {
+		return corev1.ResourceRequirements{}, errors.Wrap(err, "requests")
+	}
+	limits, err := resourceList(r.Limits)
+	if err != nil {
+		return corev1.ResourceRequirements{}, errors.Wrap(err, "limits")
+	}
+	return corev1.ResourceRequirements{Requests: requests, Limits: limits}, nil
+}
+
+func resourceList(quantities map[corev1.ResourceName]string) (corev1.ResourceList, error) {
+	if len(quantities) == 0 {
+		return nil, nil
+	}
+	list := corev1.ResourceList{}
+	for name, value := range quantities {
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", name)
+		}
+		list[name] = q
+	}
+	return list, nil
+}
+
+func validateResources(cfg *Config) error {
+	requirements, err := cfg.Webhook.Resources.Requirements()
+	if err != nil {
+		return errors.Wrap(err, "webhook.resources")
+	}
+	for name, request := range requirements.Requests {
+		if limit, ok := requirements.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return errors.Errorf("webhook.resources.requests.%s: %s exceeds the limit %s", name, request.String(), limit.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// Policies of SecurityContext.
+const (
+	// SecurityContextNamespace hardens the injected containers in the
+	// namespaces enforcing the restricted Pod Security Standard.
+	SecurityContextNamespace = "namespace"
+	// SecurityContextAlways hardens the injected containers of every pod.
+	SecurityContextAlways = "always"
+	// SecurityContextNever leaves the securityContext of the injected containers alone.
+	SecurityContextNever = "never"
+)
+
+// SecurityContext configures the securityContext of the containers injected
+// by knurse.
+type SecurityContext struct {
+	// Policy is namespace, always or never, defaults to namespace.
+	Policy string `yaml:"policy"`
+	// Container overrides fields of the securityContext knurse sets on the
+	// injected containers, e.g. runAsUser.
+	Container yaml.Node `yaml:"container"`
+}
+
+// Decode decodes Container over sc, leaving the fields it does not set alone.
+func (s SecurityContext) Decode(sc *corev1.SecurityContext) error {
+	if s.Container.Kind == 0 {
+		return nil
+	}
+	b, err := yaml.Marshal(&s.Container)
+	if err != nil {
+		return err
+	}
+	return k8syaml.UnmarshalStrict(b, sc)
+}
+
+func validateSecurityContext(cfg *Config) error {
+	s := cfg.Webhook.SecurityContext
+	switch s.Policy {
+	case "", SecurityContextNamespace, SecurityContextAlways, SecurityContextNever:
+	default:
+		return errors.Errorf("webhook.securityContext.policy: unsupported value %q", s.Policy)
+	}
+	if s.Container.Kind != 0 && s.Container.Kind != yaml.MappingNode {
+		return errors.New("webhook.securityContext.container: must be a mapping")
+	}
+	return errors.Wrap(s.Decode(&corev1.SecurityContext{}), "webhook.securityContext.container")
+}
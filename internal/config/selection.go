@@ -0,0 +1,22 @@
+package config
+
+// Annotation values opting a pod in or out of an injector.
+const (
+	AnnotationEnabled  = "enabled"
+	AnnotationDisabled = "disabled"
+)
+
+// Selection selects the pods an injector mutates. A pod annotated with
+// annotation "enabled" or "disabled" is selected accordingly, other pods are
+// selected by the labels of their namespace.
+type Selection struct {
+	// NamespaceSelector selects the namespaces of the pods, all of them when unset.
+	NamespaceSelector *LabelSelector `yaml:"namespaceSelector"`
+	// Annotation overrides the namespaceSelector for a pod, defaults to the
+	// annotation of the injector.
+	Annotation string `yaml:"annotation"`
+}
+
+func validateSelection(field string, sel Selection) error {
+	return validateLabelSelector(field+".namespaceSelector", sel.NamespaceSelector)
+}
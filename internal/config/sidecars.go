@@ -0,0 +1,79 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// SidecarsInjector is the name of the injector of the sidecar templates.
+const SidecarsInjector = "sidecars"
+
+// Kinds of containers a SidecarTemplate injects.
+const (
+	SidecarContainer     = "container"
+	SidecarInitContainer = "initContainer"
+	// SidecarNative injects an init container with restartPolicy Always.
+	SidecarNative = "sidecar"
+)
+
+// Placements of the injected containers.
+const (
+	PlacementFirst = "first"
+	PlacementLast  = "last"
+)
+
+// Sidecars configures the sidecars injector.
+type Sidecars struct {
+	Templates []SidecarTemplate `yaml:"templates"`
+}
+
+// SidecarTemplate is a container injected into the pods annotated with its
+// name. The string values of its container, volumes and volumeMounts are Go
+// templates executed over the pod, e.g. {{ .Pod.Name }},
+// {{ index .Pod.Labels "app" }}.
+type SidecarTemplate struct {
+	Name string `yaml:"name"`
+	// Kind is container, initContainer or sidecar, defaults to container.
+	Kind string `yaml:"kind"`
+	// Placement is first or last in the containers of Kind, defaults to last.
+	Placement string `yaml:"placement"`
+	// Container is the injected container.
+	Container yaml.Node `yaml:"container"`
+	// Volumes are added to the pod.
+	Volumes yaml.Node `yaml:"volumes"`
+	// VolumeMounts are attached to the existing containers named in Containers,
+	// to all containers when empty.
+	VolumeMounts yaml.Node `yaml:"volumeMounts"`
+	Containers   []string  `yaml:"containers"`
+}
+
+func validateSidecars(cfg *Config) error {
+	templates := cfg.Webhook.Sidecars.Templates
+	if cfg.InjectorEnabled(SidecarsInjector) && len(templates) == 0 {
+		return errors.New("webhook.sidecars.templates: required by the sidecars injector")
+	}
+	names := map[string]struct{}{}
+	for i, tmpl := range templates {
+		if tmpl.Name == "" {
+			return errors.Errorf("webhook.sidecars.templates[%d].name: required but empty", i)
+		}
+		if _, ok := names[tmpl.Name]; ok {
+			return errors.Errorf("webhook.sidecars.templates[%d].name: duplicate template %q", i, tmpl.Name)
+		}
+		names[tmpl.Name] = struct{}{}
+		switch tmpl.Kind {
+		case "", SidecarContainer, SidecarInitContainer, SidecarNative:
+		default:
+			return errors.Errorf("webhook.sidecars.templates[%d].kind: unsupported value %q", i, tmpl.Kind)
+		}
+		switch tmpl.Placement {
+		case "", PlacementFirst, PlacementLast:
+		default:
+			return errors.Errorf("webhook.sidecars.templates[%d].placement: unsupported value %q", i, tmpl.Placement)
+		}
+		if tmpl.Container.Kind != yaml.MappingNode {
+			return errors.Errorf("webhook.sidecars.templates[%d].container: required but empty", i)
+		}
+	}
+	return nil
+}
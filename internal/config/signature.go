@@ -0,0 +1,78 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	errMissingSignature = errors.New("bundle is not signed")
+	errInvalidSignature = errors.New("bundle signature does not match")
+)
+
+// LoadPublicKey reads a PEM encoded PKIX public key used to verify bundle signatures.
+// Only ed25519 and ECDSA keys are supported.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(b)
+}
+
+// ParsePublicKey parses a PEM encoded PKIX public key.
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found in public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse public key")
+	}
+	switch pub.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return pub, nil
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// VerifySignature checks the base64 encoded detached signature over data.
+// ed25519 signatures are computed over the raw data, ECDSA signatures are
+// ASN.1 encoded and computed over the SHA-256 digest of the data, which is
+// what `openssl dgst -sha256 -sign` produces.
+func VerifySignature(pub crypto.PublicKey, data []byte, signature string) error {
+	signature = strings.TrimSpace(signature)
+	if signature == "" {
+		return errMissingSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode signature")
+	}
+
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, sig) {
+			return errInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return errInvalidSignature
+		}
+	default:
+		return errors.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
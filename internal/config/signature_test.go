@@ -0,0 +1,94 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignature(t *testing.T) {
+	spec.Run(t, "Signature", testSignature)
+}
+
+func testSignature(t *testing.T, when spec.G, it spec.S) {
+	data := []byte("some-ca-certs-data")
+
+	marshalPublicKey := func(pub interface{}) []byte {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+
+	when("the key is ed25519", func() {
+		var (
+			key       crypto.PublicKey
+			signature string
+		)
+
+		it.Before(func() {
+			pub, priv, err := ed25519.GenerateKey(rand.Reader)
+			require.NoError(t, err)
+
+			key, err = ParsePublicKey(marshalPublicKey(pub))
+			require.NoError(t, err)
+
+			signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+		})
+
+		it("accepts a valid signature", func() {
+			assert.NoError(t, VerifySignature(key, data, signature))
+		})
+
+		it("rejects tampered data", func() {
+			assert.Equal(t, errInvalidSignature, VerifySignature(key, []byte("tampered"), signature))
+		})
+
+		it("rejects unsigned data", func() {
+			assert.Equal(t, errMissingSignature, VerifySignature(key, data, ""))
+		})
+	})
+
+	when("the key is ecdsa", func() {
+		var (
+			key       crypto.PublicKey
+			signature string
+		)
+
+		it.Before(func() {
+			priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			require.NoError(t, err)
+
+			key, err = ParsePublicKey(marshalPublicKey(&priv.PublicKey))
+			require.NoError(t, err)
+
+			digest := sha256.Sum256(data)
+			sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+			require.NoError(t, err)
+			signature = base64.StdEncoding.EncodeToString(sig)
+		})
+
+		it("accepts a valid signature", func() {
+			assert.NoError(t, VerifySignature(key, data, signature))
+		})
+
+		it("rejects tampered data", func() {
+			assert.Equal(t, errInvalidSignature, VerifySignature(key, []byte("tampered"), signature))
+		})
+	})
+
+	it("rejects malformed public keys", func() {
+		_, err := ParsePublicKey([]byte("not-a-key"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// DefaultSkipContainerNames are the sidecars managing their own trust,
+	// skipped when webhook.caCerts.skipContainers.names is unset.
+	DefaultSkipContainerNames = []string{"istio-proxy", "istio-init", "istio-validation", "linkerd-proxy", "linkerd-init"}
+	// DefaultSkipContainerImages are the images of the sidecars managing their
+	// own trust, skipped when webhook.caCerts.skipContainers.images is unset.
+	DefaultSkipContainerImages = []string{
+		"*/proxyv2", "*/proxyv2:*", "*/proxyv2@*",
+		"*/linkerd/proxy", "*/linkerd/proxy:*", "*/linkerd/proxy@*",
+		"*/linkerd/proxy-init", "*/linkerd/proxy-init:*", "*/linkerd/proxy-init@*",
+	}
+)
+
+// CaCertsSkipContainers are the containers the CA certs are not mounted into,
+// unless the pod lists them in its containers annotation.
+type CaCertsSkipContainers struct {
+	// Names of the containers.
+	Names []string `yaml:"names"`
+	// Images are patterns of the images of the containers, in which * matches
+	// any sequence of characters.
+	Images []string `yaml:"images"`
+}
+
+func validateCaCertsSkipContainers(s CaCertsSkipContainers) error {
+	for i, name := range s.Names {
+		if name == "" {
+			return errors.Errorf("webhook.caCerts.skipContainers.names[%d]: required but empty", i)
+		}
+	}
+	for i, image := range s.Images {
+		if image == "" {
+			return errors.Errorf("webhook.caCerts.skipContainers.images[%d]: required but empty", i)
+		}
+	}
+	return nil
+}
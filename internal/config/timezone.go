@@ -0,0 +1,43 @@
+package config
+
+import (
+	"time"
+	// Embed the time zone database, the knurse image may lack it.
+	_ "time/tzdata"
+
+	"github.com/pkg/errors"
+)
+
+// TimezoneInjector is the name of the injector of the TZ variable.
+const TimezoneInjector = "timezone"
+
+// Timezone configures the timezone injector, setting TZ on every container not
+// setting it already.
+type Timezone struct {
+	Selection `yaml:",inline"`
+
+	// Default is the time zone of the pods, e.g. Asia/Seoul. The annotation
+	// timezone.knurse.zezaeoh.io/tz of their namespace, or their own, overrides it.
+	Default string `yaml:"default"`
+	// Zoneinfo mounts a zoneinfo database in the containers of the pods not in
+	// UTC, for images without tzdata.
+	Zoneinfo struct {
+		Enabled bool `yaml:"enabled"`
+		// Image holds the database under /usr/share/zoneinfo and provides cp,
+		// defaults to the setupCaCertsImage.
+		Image string `yaml:"image"`
+	} `yaml:"zoneinfo"`
+}
+
+func validateTimezone(cfg *Config) error {
+	tz := cfg.Webhook.Timezone
+	if cfg.InjectorEnabled(TimezoneInjector) && tz.Default == "" {
+		return errors.New("webhook.timezone.default: required by the timezone injector")
+	}
+	if tz.Default != "" {
+		if _, err := time.LoadLocation(tz.Default); err != nil {
+			return errors.Errorf("webhook.timezone.default: unknown time zone %q", tz.Default)
+		}
+	}
+	return validateSelection("webhook.timezone", tz.Selection)
+}
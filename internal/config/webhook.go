@@ -0,0 +1,142 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WebhookEntry is a webhook entry of one of the MutatingWebhookConfigurations knurse owns.
+type WebhookEntry struct {
+	MutatingWebhook `yaml:",inline"`
+
+	ConfigName string `yaml:"configName"`
+	Name       string `yaml:"name"`
+	Path       string `yaml:"path"`
+}
+
+// MutatingWebhook configures a webhook entry of a MutatingWebhookConfiguration.
+// Unset fields fall back to knurse's defaults.
+type MutatingWebhook struct {
+	Rules              []Rule         `yaml:"rules"`
+	NamespaceSelector  *LabelSelector `yaml:"namespaceSelector"`
+	ObjectSelector     *LabelSelector `yaml:"objectSelector"`
+	FailurePolicy      string         `yaml:"failurePolicy"`
+	TimeoutSeconds     *int32         `yaml:"timeoutSeconds"`
+	ReinvocationPolicy string         `yaml:"reinvocationPolicy"`
+	SideEffects        string         `yaml:"sideEffects"`
+}
+
+type Rule struct {
+	Operations  []string `yaml:"operations"`
+	APIGroups   []string `yaml:"apiGroups"`
+	APIVersions []string `yaml:"apiVersions"`
+	Resources   []string `yaml:"resources"`
+	Scope       string   `yaml:"scope"`
+}
+
+type LabelSelector struct {
+	MatchLabels      map[string]string          `yaml:"matchLabels"`
+	MatchExpressions []LabelSelectorRequirement `yaml:"matchExpressions"`
+}
+
+// AsLabelSelector converts the selector, an empty selector matching everything
+// when nil.
+func (s *LabelSelector) AsLabelSelector() *metav1.LabelSelector {
+	converted := &metav1.LabelSelector{}
+	if s == nil {
+		return converted
+	}
+
+	if len(s.MatchLabels) > 0 {
+		converted.MatchLabels = s.MatchLabels
+	}
+	for _, expr := range s.MatchExpressions {
+		converted.MatchExpressions = append(converted.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      expr.Key,
+			Operator: metav1.LabelSelectorOperator(expr.Operator),
+			Values:   expr.Values,
+		})
+	}
+	return converted
+}
+
+type LabelSelectorRequirement struct {
+	Key      string   `yaml:"key"`
+	Operator string   `yaml:"operator"`
+	Values   []string `yaml:"values"`
+}
+
+func validateWebhookEntries(entries []WebhookEntry) error {
+	names := map[string]struct{}{}
+	for i, entry := range entries {
+		if entry.ConfigName == "" {
+			return errors.Errorf("webhook.webhooks[%d].configName: required but empty", i)
+		}
+		if entry.Name == "" {
+			return errors.Errorf("webhook.webhooks[%d].name: required but empty", i)
+		}
+		if !strings.HasPrefix(entry.Path, "/") {
+			return errors.Errorf("webhook.webhooks[%d].path: must be an absolute path", i)
+		}
+		key := entry.ConfigName + "/" + entry.Name
+		if _, ok := names[key]; ok {
+			return errors.Errorf("webhook.webhooks[%d].name: duplicate webhook %q in %q", i, entry.Name, entry.ConfigName)
+		}
+		names[key] = struct{}{}
+		if err := validateMutatingWebhook(entry.MutatingWebhook); err != nil {
+			return errors.Wrapf(err, "webhook.webhooks[%d]", i)
+		}
+	}
+	return nil
+}
+
+func validateMutatingWebhook(wh MutatingWebhook) error {
+	switch wh.FailurePolicy {
+	case "", "Ignore", "Fail":
+	default:
+		return errors.Errorf("failurePolicy: unsupported value %q", wh.FailurePolicy)
+	}
+	switch wh.ReinvocationPolicy {
+	case "", "Never", "IfNeeded":
+	default:
+		return errors.Errorf("reinvocationPolicy: unsupported value %q", wh.ReinvocationPolicy)
+	}
+	switch wh.SideEffects {
+	case "", "None", "NoneOnDryRun":
+	default:
+		return errors.Errorf("sideEffects: unsupported value %q", wh.SideEffects)
+	}
+	if t := wh.TimeoutSeconds; t != nil && (*t < 1 || *t > 30) {
+		return errors.New("timeoutSeconds: must be between 1 and 30")
+	}
+	for i, rule := range wh.Rules {
+		if len(rule.Operations) == 0 || len(rule.APIVersions) == 0 || len(rule.Resources) == 0 {
+			return errors.Errorf("rules[%d]: operations, apiVersions and resources are required", i)
+		}
+	}
+	for name, selector := range map[string]*LabelSelector{"namespaceSelector": wh.NamespaceSelector, "objectSelector": wh.ObjectSelector} {
+		if err := validateLabelSelector(name, selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLabelSelector(field string, selector *LabelSelector) error {
+	if selector == nil {
+		return nil
+	}
+	for i, expr := range selector.MatchExpressions {
+		if expr.Key == "" {
+			return errors.Errorf("%s.matchExpressions[%d].key: required but empty", field, i)
+		}
+		switch expr.Operator {
+		case "In", "NotIn", "Exists", "DoesNotExist":
+		default:
+			return errors.Errorf("%s.matchExpressions[%d].operator: unsupported value %q", field, i, expr.Operator)
+		}
+	}
+	return nil
+}
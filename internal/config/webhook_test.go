@@ -0,0 +1,91 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWebhookEntries(t *testing.T) {
+	spec.Run(t, "WebhookEntries", testWebhookEntries)
+}
+
+func testWebhookEntries(t *testing.T, when spec.G, it spec.S) {
+	validate := func(entries string) error {
+		var parsed []WebhookEntry
+		require.NoError(t, yaml.Unmarshal([]byte(entries), &parsed))
+		return validateWebhookEntries(parsed)
+	}
+
+	when("#validateWebhookEntries", func() {
+		it("accepts entries of the same name in different configurations", func() {
+			assert.NoError(t, validate(`
+- configName: knurse-webhook
+  name: ca-certs.knurse.zezaeoh.io
+  path: /ca-certs
+  failurePolicy: Fail
+  namespaceSelector:
+    matchExpressions:
+      - key: ca-certs
+        operator: Exists
+- configName: knurse-webhook-ignore
+  name: ca-certs.knurse.zezaeoh.io
+  path: /ca-certs
+  failurePolicy: Ignore
+`))
+		})
+
+		for _, tc := range []struct {
+			name, entries, err string
+		}{
+			{
+				name:    "entries without a configName",
+				entries: `[{name: a, path: /a}]`,
+				err:     "webhook.webhooks[0].configName: required but empty",
+			},
+			{
+				name:    "entries without a name",
+				entries: `[{configName: c, path: /a}]`,
+				err:     "webhook.webhooks[0].name: required but empty",
+			},
+			{
+				name:    "relative paths",
+				entries: `[{configName: c, name: a, path: a}]`,
+				err:     "webhook.webhooks[0].path: must be an absolute path",
+			},
+			{
+				name:    "duplicate entries of a configuration",
+				entries: `[{configName: c, name: a, path: /a}, {configName: c, name: a, path: /b}]`,
+				err:     `webhook.webhooks[1].name: duplicate webhook "a" in "c"`,
+			},
+			{
+				name:    "unsupported failure policies",
+				entries: `[{configName: c, name: a, path: /a, failurePolicy: Retry}]`,
+				err:     `webhook.webhooks[0]: failurePolicy: unsupported value "Retry"`,
+			},
+			{
+				name:    "timeouts out of range",
+				entries: `[{configName: c, name: a, path: /a, timeoutSeconds: 31}]`,
+				err:     "webhook.webhooks[0]: timeoutSeconds: must be between 1 and 30",
+			},
+			{
+				name:    "incomplete rules",
+				entries: `[{configName: c, name: a, path: /a, rules: [{operations: [CREATE]}]}]`,
+				err:     "webhook.webhooks[0]: rules[0]: operations, apiVersions and resources are required",
+			},
+			{
+				name:    "invalid selectors",
+				entries: `[{configName: c, name: a, path: /a, objectSelector: {matchExpressions: [{key: k, operator: Is}]}}]`,
+				err:     `webhook.webhooks[0]: objectSelector.matchExpressions[0].operator: unsupported value "Is"`,
+			},
+		} {
+			tc := tc
+			it("rejects "+tc.name, func() {
+				assert.EqualError(t, validate(tc.entries), tc.err)
+			})
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package enum
 
 const (
-	SETUP_CA_CERT_DATA = "CA_CERTS_DATA"
-	SETUP_WORKSPACE    = "/workspace"
+	SETUP_CA_CERT_DATA        = "CA_CERTS_DATA"
+	SETUP_CA_CERT_DIGEST      = "CA_CERTS_DIGEST"
+	SETUP_CA_CERT_BUNDLE_NAME = "CA_CERTS_BUNDLE_NAME"
+	SETUP_WORKSPACE           = "/workspace"
 )
@@ -0,0 +1,124 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Problem is a misconfiguration found on a MutatingWebhookConfiguration.
+type Problem struct {
+	Webhook string `json:"webhook,omitempty"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Report is the last set of problems detected on a configuration.
+type Report struct {
+	Problems   []Problem `json:"problems"`
+	DetectedAt time.Time `json:"detectedAt"`
+	// Repaired is set once knurse fixed the problems.
+	Repaired bool `json:"repaired"`
+}
+
+// Tracker records the last problems detected on the MutatingWebhookConfigurations
+// knurse owns. Only the replica reconciling a configuration knows about its
+// problems, the other replicas report it as healthy.
+type Tracker struct {
+	mu      sync.RWMutex
+	reports map[string]Report
+	now     func() time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{reports: map[string]Report{}, now: time.Now}
+}
+
+// Detected records the problems detected on the configuration name, which
+// stay reported until Clear, repaired or not.
+func (t *Tracker) Detected(name string, problems []Problem, repaired bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reports[name] = Report{
+		Problems:   append([]Problem(nil), problems...),
+		DetectedAt: t.now(),
+		Repaired:   repaired,
+	}
+}
+
+// Clear forgets the problems of the configuration name, found correct as is.
+func (t *Tracker) Clear(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.reports, name)
+}
+
+// Reports returns the last detected problems by configuration name.
+func (t *Tracker) Reports() map[string]Report {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	reports := make(map[string]Report, len(t.reports))
+	for name, r := range t.reports {
+		r.Problems = append([]Problem(nil), r.Problems...)
+		reports[name] = r
+	}
+	return reports
+}
+
+// Ready returns whether every configuration exists and its problems are repaired.
+func (t *Tracker) Ready() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, r := range t.reports {
+		if !r.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+type configuration struct {
+	Name string `json:"name"`
+	Report
+}
+
+// StatusHandler answers the last problems detected on the configurations, with
+// the time they were detected and whether they were repaired, with a 200 either
+// way. Repaired problems are reported until a reconcile finds the configuration
+// correct as is.
+func StatusHandler(t *Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reports := t.Reports()
+		configurations := make([]configuration, 0, len(reports))
+		for name, r := range reports {
+			configurations = append(configurations, configuration{Name: name, Report: r})
+		}
+		sort.Slice(configurations, func(i, j int) bool { return configurations[i].Name < configurations[j].Name })
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Healthy        bool            `json:"healthy"`
+			Configurations []configuration `json:"configurations,omitempty"`
+		}{t.Ready(), configurations})
+	})
+}
+
+// ReadyHandler answers 503 while a configuration is missing or knurse fails to
+// repair it, 200 otherwise.
+func ReadyHandler(t *Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !t.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("webhook configurations are not repaired, see /status\n"))
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlers(t *testing.T) {
+	spec.Run(t, "Handlers", testHandlers)
+}
+
+func testHandlers(t *testing.T, when spec.G, it spec.S) {
+	var tracker *Tracker
+	problems := []Problem{{Webhook: "ca-certs.knurse.zezaeoh.io", Reason: "CABundleMismatch", Message: "untrusted"}}
+
+	it.Before(func() {
+		tracker = NewTracker()
+		tracker.now = func() time.Time { return time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC) }
+	})
+
+	serve := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	when("StatusHandler", func() {
+		it("reports the unrepaired problems without failing", func() {
+			tracker.Detected("knurse-webhook", problems, false)
+			rec := serve(StatusHandler(tracker), "/status")
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{"healthy":false,"configurations":[{"name":"knurse-webhook","problems":[
+				{"webhook":"ca-certs.knurse.zezaeoh.io","reason":"CABundleMismatch","message":"untrusted"}],
+				"detectedAt":"2021-06-01T12:00:00Z","repaired":false}]}`, rec.Body.String())
+		})
+
+		it("keeps reporting the repaired problems", func() {
+			tracker.Detected("knurse-webhook", problems, true)
+			rec := serve(StatusHandler(tracker), "/status")
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{"healthy":true,"configurations":[{"name":"knurse-webhook","problems":[
+				{"webhook":"ca-certs.knurse.zezaeoh.io","reason":"CABundleMismatch","message":"untrusted"}],
+				"detectedAt":"2021-06-01T12:00:00Z","repaired":true}]}`, rec.Body.String())
+		})
+
+		it("reports healthy once the problems are cleared", func() {
+			tracker.Detected("knurse-webhook", problems, false)
+			tracker.Clear("knurse-webhook")
+			rec := serve(StatusHandler(tracker), "/status")
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{"healthy":true}`, rec.Body.String())
+		})
+	})
+
+	when("ReadyHandler", func() {
+		it("is not ready while problems are not repaired", func() {
+			tracker.Detected("knurse-webhook", problems, false)
+			assert.Equal(t, http.StatusServiceUnavailable, serve(ReadyHandler(tracker), "/readyz").Code)
+		})
+
+		it("is ready once the problems are repaired", func() {
+			tracker.Detected("knurse-webhook", problems, true)
+			assert.Equal(t, http.StatusOK, serve(ReadyHandler(tracker), "/readyz").Code)
+		})
+
+		it("is ready without problems", func() {
+			assert.Equal(t, http.StatusOK, serve(ReadyHandler(tracker), "/readyz").Code)
+		})
+	})
+}
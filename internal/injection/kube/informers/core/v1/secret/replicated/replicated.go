@@ -0,0 +1,42 @@
+package replicated
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	v1 "k8s.io/client-go/informers/core/v1"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+)
+
+// LabelKey labels the Secrets knurse replicates into the namespaces, its value
+// is the name of the source Secret.
+const LabelKey = "knurse.zezaeoh.io/replicated-from"
+
+// Key is used for associating the Informer inside the context.Context.
+type Key struct{}
+
+// WithInformer sets up an informer on the Secrets labelled as replicated by
+// knurse only, watching every Secret of the cluster is costly. It is not
+// registered by default as it needs access to the Secrets of every namespace,
+// register it with injection.Default.RegisterInformer when replicating.
+func WithInformer(ctx context.Context) (context.Context, controller.Informer) {
+	f := informers.NewSharedInformerFactoryWithOptions(kubeclient.Get(ctx), controller.GetResyncPeriod(ctx),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = LabelKey
+		}))
+	inf := f.Core().V1().Secrets()
+	return context.WithValue(ctx, Key{}, inf), inf.Informer()
+}
+
+// Get extracts the typed informer from the context.
+func Get(ctx context.Context) v1.SecretInformer {
+	untyped := ctx.Value(Key{})
+	if untyped == nil {
+		logging.FromContext(ctx).Panic(
+			"Unable to fetch k8s.io/client-go/informers/core/v1.SecretInformer from context.")
+	}
+	return untyped.(v1.SecretInformer)
+}
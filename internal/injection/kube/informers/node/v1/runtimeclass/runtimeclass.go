@@ -0,0 +1,36 @@
+package runtimeclass
+
+import (
+	"context"
+
+	v1 "k8s.io/client-go/informers/node/v1"
+	"knative.dev/pkg/client/injection/kube/informers/factory"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection"
+	"knative.dev/pkg/logging"
+)
+
+// knative.dev/pkg does not ship a RuntimeClass informer, this registers one
+// on the cluster wide kube informer factory.
+func init() {
+	injection.Default.RegisterInformer(withInformer)
+}
+
+// Key is used for associating the Informer inside the context.Context.
+type Key struct{}
+
+func withInformer(ctx context.Context) (context.Context, controller.Informer) {
+	f := factory.Get(ctx)
+	inf := f.Node().V1().RuntimeClasses()
+	return context.WithValue(ctx, Key{}, inf), inf.Informer()
+}
+
+// Get extracts the typed informer from the context.
+func Get(ctx context.Context) v1.RuntimeClassInformer {
+	untyped := ctx.Value(Key{})
+	if untyped == nil {
+		logging.FromContext(ctx).Panic(
+			"Unable to fetch k8s.io/client-go/informers/node/v1.RuntimeClassInformer from context.")
+	}
+	return untyped.(v1.RuntimeClassInformer)
+}
@@ -0,0 +1,36 @@
+package configmap
+
+import (
+	"context"
+
+	v1 "k8s.io/client-go/informers/core/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection"
+	"knative.dev/pkg/injection/clients/namespacedkube/informers/factory"
+	"knative.dev/pkg/logging"
+)
+
+// knative.dev/pkg only ships a namespaced secret informer, this registers
+// the ConfigMap counterpart scoped to the system namespace.
+func init() {
+	injection.Default.RegisterInformer(withInformer)
+}
+
+// Key is used for associating the Informer inside the context.Context.
+type Key struct{}
+
+func withInformer(ctx context.Context) (context.Context, controller.Informer) {
+	f := factory.Get(ctx)
+	inf := f.Core().V1().ConfigMaps()
+	return context.WithValue(ctx, Key{}, inf), inf.Informer()
+}
+
+// Get extracts the typed informer from the context.
+func Get(ctx context.Context) v1.ConfigMapInformer {
+	untyped := ctx.Value(Key{})
+	if untyped == nil {
+		logging.FromContext(ctx).Panic(
+			"Unable to fetch k8s.io/client-go/informers/core/v1.ConfigMapInformer from context.")
+	}
+	return untyped.(v1.ConfigMapInformer)
+}
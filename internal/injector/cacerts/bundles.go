@@ -0,0 +1,170 @@
+package cacerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret"
+	"knative.dev/pkg/logging"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	"knative.dev/pkg/system"
+
+	"github.com/zezaeoh/knurse/internal/bundle"
+	"github.com/zezaeoh/knurse/internal/config"
+	configmapinformer "github.com/zezaeoh/knurse/internal/injection/namespacedkube/informers/core/v1/configmap"
+)
+
+const bundlesQueueName = "CaCertsBundles"
+
+// NewBundleController constructs a controller loading the configured bundles into the store.
+func NewBundleController(
+	ctx context.Context,
+	cfg *config.Config,
+	bundles *bundle.Store,
+) *controller.Impl {
+	secretInformer := secret.Get(ctx)
+	configmapInformer := configmapinformer.Get(ctx)
+	logger := logging.FromContext(ctx)
+
+	if cfg.Webhook.CaCerts.Data != "" {
+		if err := bundles.Set(string(bundle.SourceConfig), bundle.SourceConfig, cfg.Webhook.CaCerts.Data, cfg.Webhook.CaCerts.Signature); err != nil {
+			logger.Errorw("Failed to load ca certs bundle from config", zap.Error(err))
+		}
+	}
+	for _, src := range cfg.Webhook.CaCerts.Sources {
+		bundles.Register(sourceOf(src))
+	}
+
+	r := &bundleReconciler{
+		sources:         cfg.Webhook.CaCerts.Sources,
+		bundles:         bundles,
+		secretlister:    secretInformer.Lister(),
+		configmaplister: configmapInformer.Lister(),
+	}
+	c := controller.NewImplFull(r, controller.ControllerOptions{WorkQueueName: bundlesQueueName, Logger: logger.Named(bundlesQueueName)})
+
+	// Every source change reloads all bundles, so a single key is enqueued.
+	key := types.NamespacedName{Namespace: system.Namespace(), Name: bundlesQueueName}
+	reload := controller.HandleAll(func(interface{}) { c.EnqueueKey(key) })
+	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: filterSources(cfg.Webhook.CaCerts.Sources, func(src config.CaCertsSource) *config.KeyRef { return src.Secret }),
+		Handler:    reload,
+	})
+	configmapInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: filterSources(cfg.Webhook.CaCerts.Sources, func(src config.CaCertsSource) *config.KeyRef { return src.ConfigMap }),
+		Handler:    reload,
+	})
+	return c
+}
+
+// bundleReconciler reloads the bundles of all Secret and ConfigMap sources into the store.
+// Every replica admits pods, so unlike the webhook reconciler it does not wait for leadership.
+type bundleReconciler struct {
+	pkgreconciler.LeaderAwareFuncs
+
+	sources []config.CaCertsSource
+	bundles *bundle.Store
+
+	secretlister    corelisters.SecretLister
+	configmaplister corelisters.ConfigMapLister
+}
+
+// Reconcile implements controller.Reconciler
+func (r *bundleReconciler) Reconcile(ctx context.Context, _ string) error {
+	logger := logging.FromContext(ctx)
+
+	for _, src := range r.sources {
+		name, source := sourceOf(src)
+		data, signature, err := r.readSource(src)
+		switch {
+		case err == nil:
+			err = r.bundles.Set(name, source, data, signature)
+		case apierrors.IsNotFound(err) || errors.Is(err, errMissingKey):
+			// Keeping the data of a deleted source would keep trusting
+			// the certificates it was deleted to revoke.
+			r.bundles.Clear(name, source, err)
+		default:
+			r.bundles.SetError(name, source, err)
+		}
+		if err != nil {
+			logger.Errorw("Failed to reload ca certs bundle", zap.String("bundle", name), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+var errMissingKey = errors.New("missing key")
+
+func (r *bundleReconciler) readSource(src config.CaCertsSource) (data, signature string, err error) {
+	if ref := src.Secret; ref != nil {
+		secret, err := r.secretlister.Secrets(system.Namespace()).Get(ref.Name)
+		if err != nil {
+			return "", "", err
+		}
+		b, ok := secret.Data[ref.Key]
+		if !ok {
+			return "", "", fmt.Errorf("secret %q: %w %q", ref.Name, errMissingKey, ref.Key)
+		}
+		return string(b), string(secret.Data[ref.Key+".sig"]), nil
+	}
+
+	ref := src.ConfigMap
+	cm, err := r.configmaplister.ConfigMaps(system.Namespace()).Get(ref.Name)
+	if err != nil {
+		return "", "", err
+	}
+	if d, ok := cm.Data[ref.Key]; ok {
+		return d, configMapValue(cm, ref.Key+".sig"), nil
+	}
+	if b, ok := cm.BinaryData[ref.Key]; ok {
+		return string(b), configMapValue(cm, ref.Key+".sig"), nil
+	}
+	return "", "", fmt.Errorf("configmap %q: %w %q", ref.Name, errMissingKey, ref.Key)
+}
+
+// configMapValue returns the value of key, from data or binaryData.
+func configMapValue(cm *corev1.ConfigMap, key string) string {
+	if d, ok := cm.Data[key]; ok {
+		return d
+	}
+	return string(cm.BinaryData[key])
+}
+
+// sourceOf returns the bundle name and source type of a Secret or ConfigMap source.
+func sourceOf(src config.CaCertsSource) (string, bundle.Source) {
+	if ref := src.Secret; ref != nil {
+		return fmt.Sprintf("%s/%s/%s", bundle.SourceSecret, ref.Name, ref.Key), bundle.SourceSecret
+	}
+	ref := src.ConfigMap
+	return fmt.Sprintf("%s/%s/%s", bundle.SourceConfigMap, ref.Name, ref.Key), bundle.SourceConfigMap
+}
+
+// filterSources accepts the objects of the system namespace referenced by the sources.
+func filterSources(sources []config.CaCertsSource, ref func(config.CaCertsSource) *config.KeyRef) func(interface{}) bool {
+	names := map[string]struct{}{}
+	for _, src := range sources {
+		if r := ref(src); r != nil {
+			names[r.Name] = struct{}{}
+		}
+	}
+	return func(obj interface{}) bool {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		object, ok := obj.(metav1.Object)
+		if !ok || object.GetNamespace() != system.Namespace() {
+			return false
+		}
+		_, ok = names[object.GetName()]
+		return ok
+	}
+}
@@ -0,0 +1,101 @@
+package cacerts
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
+
+	"github.com/zezaeoh/knurse/internal/bundle"
+	"github.com/zezaeoh/knurse/internal/config"
+)
+
+func TestBundleReconciler(t *testing.T) {
+	spec.Run(t, "BundleReconciler", testBundleReconciler)
+}
+
+func testBundleReconciler(t *testing.T, when spec.G, it spec.S) {
+	const caCertData = `-----BEGIN CERTIFICATE-----
+MIIBbjCCAROgAwIBAgIQE6ttkwhxiyWXdGyCYeXx2TAKBggqhkjOPQQDAjAVMRMw
+EQYDVQQDEwp6ZXphZW9oLmlvMB4XDTIyMDMwMTExMDMxM1oXDTMyMDIyNzExMDMx
+M1owFTETMBEGA1UEAxMKemV6YWVvaC5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEH
+A0IABHX/JsHeUP4N3nqPrvxomMfEAZuVNZ4gqUxkYfZ4zBeInce/l0VJ3zs6T1UF
+CCrfz4Ikh808Hqn0WOkuuTrjAfqjRTBDMA4GA1UdDwEB/wQEAwIBBjASBgNVHRMB
+Af8ECDAGAQH/AgEBMB0GA1UdDgQWBBRZCI0gAEYflEredZJdcb4g8TaCSzAKBggq
+hkjOPQQDAgNJADBGAiEA6r77RFykldPNKKIzyazuDjQltBQpP5FXJH8u3jDx3tYC
+IQDo8vaYB9ySwxkM4aQvEZVvOUZf/uYVkwenThmIxDbw8w==
+-----END CERTIFICATE-----`
+
+	var (
+		r         *bundleReconciler
+		store     *bundle.Store
+		indexer   cache.Indexer
+		signature []byte
+	)
+
+	it.Before(func() {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		signature = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(caCertData))))
+
+		store = bundle.NewStore(pub)
+		indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		r = &bundleReconciler{
+			sources:         []config.CaCertsSource{{ConfigMap: &config.KeyRef{Name: "corp-ca", Key: "ca.crt"}}},
+			bundles:         store,
+			configmaplister: corelisters.NewConfigMapLister(indexer),
+		}
+	})
+
+	when("the bundle of a ConfigMap is binary", func() {
+		it("verifies it with the binary signature", func() {
+			require.NoError(t, indexer.Add(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "corp-ca", Namespace: system.Namespace()},
+				BinaryData: map[string][]byte{
+					"ca.crt":     []byte(caCertData),
+					"ca.crt.sig": signature,
+				},
+			}))
+
+			require.NoError(t, r.Reconcile(context.TODO(), ""))
+
+			assert.Equal(t, caCertData, store.Data())
+			assert.Empty(t, store.Bundles()[0].LastError)
+		})
+
+		it("verifies it with a text signature", func() {
+			require.NoError(t, indexer.Add(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "corp-ca", Namespace: system.Namespace()},
+				Data:       map[string]string{"ca.crt.sig": string(signature)},
+				BinaryData: map[string][]byte{"ca.crt": []byte(caCertData)},
+			}))
+
+			require.NoError(t, r.Reconcile(context.TODO(), ""))
+
+			assert.Equal(t, caCertData, store.Data())
+		})
+
+		it("rejects it without a signature", func() {
+			require.NoError(t, indexer.Add(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "corp-ca", Namespace: system.Namespace()},
+				BinaryData: map[string][]byte{"ca.crt": []byte(caCertData)},
+			}))
+
+			require.NoError(t, r.Reconcile(context.TODO(), ""))
+
+			assert.Empty(t, store.Data())
+			assert.NotEmpty(t, store.Bundles()[0].LastError)
+		})
+	})
+}
@@ -0,0 +1,320 @@
+package cacerts
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	nodelisters "k8s.io/client-go/listers/node/v1"
+	"knative.dev/pkg/logging"
+
+	"github.com/zezaeoh/knurse/internal/bundle"
+	"github.com/zezaeoh/knurse/internal/certs"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/enum"
+	runtimeclassinformer "github.com/zezaeoh/knurse/internal/injection/kube/informers/node/v1/runtimeclass"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+const (
+	initContainerName = "setup-ca-certs"
+	caCertsVolumeName = "ca-certs"
+	caCertsMountPath  = "/etc/ssl/certs"
+	// bundleFile is the bundle written by setup-ca-certs, mounted alone into
+	// the containers already mounting a volume at caCertsMountPath.
+	bundleFile = "ca-certificates.crt"
+
+	defaultBundleName = "ca-certs"
+)
+
+// Injector adds an init container writing the CA certs bundle into a volume
+// mounted over the trust store of every container.
+type Injector struct {
+	bundleName        string
+	setupCaCertsImage string
+	bundles           *bundle.Store
+	mount             config.CaCertsMount
+	conflicts         config.CaCertsConflicts
+	skip              config.CaCertsSkipContainers
+
+	runtimeclasslister nodelisters.RuntimeClassLister
+}
+
+// New constructs the CA certs injector.
+func New(cfg config.CaCerts, bundles *bundle.Store, runtimeclasslister nodelisters.RuntimeClassLister) *Injector {
+	bundleName := cfg.Name
+	if bundleName == "" {
+		bundleName = defaultBundleName
+	}
+	return &Injector{
+		bundleName:         bundleName,
+		setupCaCertsImage:  cfg.SetupCaCertsImage,
+		bundles:            bundles,
+		mount:              cfg.Mount,
+		conflicts:          cfg.Conflicts,
+		skip:               cfg.SkipContainers,
+		runtimeclasslister: runtimeclasslister,
+	}
+}
+
+// NewFactory returns the factory of the CA certs injector, injecting the bundles of store.
+func NewFactory(bundles *bundle.Store) injector.Factory {
+	return func(ctx context.Context, cfg *config.Config, _ config.InjectorConfig) (injector.Injector, error) {
+		if mount := cfg.Webhook.CaCerts.Mount; mount.Mode == config.MountFiles && mount.HashLinks {
+			logging.FromContext(ctx).Warn("webhook.caCerts.mount.hashLinks: the hash links mounted by subPath hide " +
+				"the links of the images with the same name, the image certificates sharing a subject hash with an injected one are not found by OpenSSL")
+		}
+		return New(cfg.Webhook.CaCerts, bundles, runtimeclassinformer.Get(ctx).Lister()), nil
+	}
+}
+
+// Name implements injector.Injector
+func (i *Injector) Name() string {
+	return config.CaCertsInjector
+}
+
+// Match implements injector.Injector
+func (i *Injector) Match(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	if i.bundles.Data() == "" {
+		return false, nil
+	}
+	// Overlapping webhook entries and reinvocations admit the pod again.
+	if injector.HasContainer(pod, initContainerName) {
+		logging.FromContext(ctx).Info("Skipping pod: CA certs are injected already")
+		return false, nil
+	}
+
+	var raw []byte
+	if req := injector.GetRequest(ctx); req != nil {
+		raw = req.Object.Raw
+	}
+	reason, ok, err := i.nonLinux(raw, pod)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		logging.FromContext(ctx).Infof("Skipping non-linux pod: %s", reason)
+		return false, nil
+	}
+	if !i.targets(pod).selectsAny(pod) {
+		logging.FromContext(ctx).Info("Skipping pod: none of its containers is targeted")
+		return false, nil
+	}
+	return true, nil
+}
+
+// Inject implements injector.Injector
+func (i *Injector) Inject(ctx context.Context, pod *corev1.Pod) error {
+	caCertData := i.bundles.Data()
+
+	volumeName, err := i.volumeName(ctx, pod)
+	if err != nil {
+		return err
+	}
+	volume := corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+
+	files, err := i.mountedFiles(caCertData)
+	if err != nil {
+		return err
+	}
+	targets := i.targets(pod)
+	if missing := targets.missing(pod); len(missing) > 0 {
+		sort.Strings(missing)
+		injector.Warn(ctx, "knurse: %s lists unknown containers: %s", ContainersAnnotation, strings.Join(missing, ","))
+	}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for j := range containers {
+			if !targets.selects(&containers[j]) {
+				continue
+			}
+			if err := i.mountInto(ctx, &containers[j], pod.Spec.Volumes, volumeName, files); err != nil {
+				return err
+			}
+		}
+	}
+
+	container := corev1.Container{
+		Name:  initContainerName,
+		Image: i.setupCaCertsImage,
+		Env: []corev1.EnvVar{
+			{
+				Name:  enum.SETUP_CA_CERT_DATA,
+				Value: caCertData,
+			},
+			{
+				Name:  enum.SETUP_CA_CERT_DIGEST,
+				Value: certs.Digest([]byte(caCertData)),
+			},
+			{
+				Name:  enum.SETUP_CA_CERT_BUNDLE_NAME,
+				Value: i.bundleName,
+			},
+		},
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		// Surface verification failures in the pod status.
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		WorkingDir:               enum.SETUP_WORKSPACE,
+		Args:                     i.args(),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      volumeName,
+				MountPath: enum.SETUP_WORKSPACE,
+			},
+		},
+	}
+	pod.Spec.InitContainers = append([]corev1.Container{container}, pod.Spec.InitContainers...)
+	return nil
+}
+
+// volumeName returns the name of the CA certs volume, generating a unique one
+// when the pod already holds a volume named like it.
+func (i *Injector) volumeName(ctx context.Context, pod *corev1.Pod) (string, error) {
+	if !hasVolume(pod, caCertsVolumeName) {
+		return caCertsVolumeName, nil
+	}
+	if i.conflicts.Volume == config.ConflictDeny {
+		return "", errors.Errorf("the pod already has a volume named %q", caCertsVolumeName)
+	}
+	name := caCertsVolumeName
+	for n := 1; hasVolume(pod, name); n++ {
+		name = fmt.Sprintf("%s-%d", caCertsVolumeName, n)
+	}
+	injector.Warn(ctx, "knurse: the pod already has a volume named %q, the CA certs are mounted from volume %q", caCertsVolumeName, name)
+	return name, nil
+}
+
+// mountedFile is a file of the trust store mounted alone at name.
+type mountedFile struct {
+	name    string
+	subPath string
+}
+
+// mountedFiles returns the files mounted in files mode, nil in directory mode.
+func (i *Injector) mountedFiles(caCertData string) ([]mountedFile, error) {
+	if i.mount.Mode != config.MountFiles {
+		return nil, nil
+	}
+	var files []mountedFile
+	for _, file := range i.mount.MountedFiles() {
+		files = append(files, mountedFile{name: file, subPath: file})
+	}
+	if i.mount.HashLinks {
+		hashes, err := certs.HashFiles([]byte(caCertData))
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			files = append(files, mountedFile{name: hash, subPath: path.Join(certs.HashDir, hash)})
+		}
+	}
+	return files, nil
+}
+
+// args returns the arguments of setup-ca-certs, writing the hash files when
+// they are mounted.
+func (i *Injector) args() []string {
+	if i.mount.Mode == config.MountFiles && i.mount.HashLinks {
+		return []string{"--format", "store,manifest,hashes"}
+	}
+	return nil
+}
+
+// mountInto mounts the CA certs volume into the container, resolving the
+// conflicts with the volumes it already mounts at the trust store. files are
+// mounted alone, the whole volume when there are none.
+func (i *Injector) mountInto(ctx context.Context, c *corev1.Container, volumes []corev1.Volume, volumeName string, files []mountedFile) error {
+	if len(files) > 0 {
+		return i.mountFiles(ctx, c, volumes, volumeName, files)
+	}
+
+	existing := injector.MountAt(c, caCertsMountPath)
+	if existing == nil {
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      volumeName,
+			MountPath: caCertsMountPath,
+			ReadOnly:  true,
+		})
+		return nil
+	}
+
+	switch i.conflicts.Mount {
+	case config.ConflictDeny:
+		return errors.Errorf("container %q already mounts volume %q at %s", c.Name, existing.Name, caCertsMountPath)
+	case config.ConflictSubPath:
+		mounts := len(c.VolumeMounts)
+		if err := i.mountFiles(ctx, c, volumes, volumeName, []mountedFile{{name: bundleFile, subPath: bundleFile}}); err != nil {
+			return err
+		}
+		if len(c.VolumeMounts) > mounts {
+			injector.Warn(ctx, "knurse: container %q already mounts volume %q at %s, only the CA certs bundle is mounted at %s", c.Name, existing.Name, caCertsMountPath, path.Join(caCertsMountPath, bundleFile))
+		}
+	default:
+		injector.Warn(ctx, "knurse: container %q already mounts volume %q at %s, it gets no CA certs", c.Name, existing.Name, caCertsMountPath)
+	}
+	return nil
+}
+
+// mountFiles mounts the files of the CA certs volume into the trust store of
+// the container, skipping the paths it already mounts a volume at. The files
+// cannot be mounted into a read-only volume mounted at the trust store, the
+// container would fail to start: the pod is denied unless the conflicts are
+// skipped.
+func (i *Injector) mountFiles(ctx context.Context, c *corev1.Container, volumes []corev1.Volume, volumeName string, files []mountedFile) error {
+	if existing := injector.MountAt(c, caCertsMountPath); existing != nil && readOnly(existing, volumes) {
+		if i.conflicts.Mount == config.ConflictSubPath || i.conflicts.Mount == config.ConflictDeny {
+			return errors.Errorf("container %q mounts read-only volume %q at %s, the CA certs cannot be mounted into it", c.Name, existing.Name, caCertsMountPath)
+		}
+		injector.Warn(ctx, "knurse: container %q mounts read-only volume %q at %s, it gets no CA certs", c.Name, existing.Name, caCertsMountPath)
+		return nil
+	}
+	for _, file := range files {
+		mountPath := path.Join(caCertsMountPath, file.name)
+		if existing := injector.MountAt(c, mountPath); existing != nil {
+			if i.conflicts.Mount == config.ConflictDeny {
+				return errors.Errorf("container %q already mounts volume %q at %s", c.Name, existing.Name, mountPath)
+			}
+			injector.Warn(ctx, "knurse: container %q already mounts volume %q at %s, it gets no CA certs there", c.Name, existing.Name, mountPath)
+			continue
+		}
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      volumeName,
+			MountPath: mountPath,
+			SubPath:   file.subPath,
+			ReadOnly:  true,
+		})
+	}
+	return nil
+}
+
+// readOnly returns whether the volume of mount is mounted read-only. ConfigMap,
+// Secret, projected and downwardAPI volumes always are.
+func readOnly(mount *corev1.VolumeMount, volumes []corev1.Volume) bool {
+	if mount.ReadOnly {
+		return true
+	}
+	for _, v := range volumes {
+		if v.Name == mount.Name {
+			return v.ConfigMap != nil || v.Secret != nil || v.Projected != nil || v.DownwardAPI != nil
+		}
+	}
+	return false
+}
+
+func hasVolume(pod *corev1.Pod, name string) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
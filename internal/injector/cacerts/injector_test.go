@@ -0,0 +1,376 @@
+package cacerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/bundle"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	nodelisters "k8s.io/client-go/listers/node/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/ptr"
+)
+
+func TestInjector(t *testing.T) {
+	spec.Run(t, "Injector", testInjector)
+}
+
+func testInjector(t *testing.T, when spec.G, it spec.S) {
+	const caCertData = `-----BEGIN CERTIFICATE-----
+MIIBbjCCAROgAwIBAgIQE6ttkwhxiyWXdGyCYeXx2TAKBggqhkjOPQQDAjAVMRMw
+EQYDVQQDEwp6ZXphZW9oLmlvMB4XDTIyMDMwMTExMDMxM1oXDTMyMDIyNzExMDMx
+M1owFTETMBEGA1UEAxMKemV6YWVvaC5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEH
+A0IABHX/JsHeUP4N3nqPrvxomMfEAZuVNZ4gqUxkYfZ4zBeInce/l0VJ3zs6T1UF
+CCrfz4Ikh808Hqn0WOkuuTrjAfqjRTBDMA4GA1UdDwEB/wQEAwIBBjASBgNVHRMB
+Af8ECDAGAQH/AgEBMB0GA1UdDgQWBBRZCI0gAEYflEredZJdcb4g8TaCSzAKBggq
+hkjOPQQDAgNJADBGAiEA6r77RFykldPNKKIzyazuDjQltBQpP5FXJH8u3jDx3tYC
+IQDo8vaYB9ySwxkM4aQvEZVvOUZf/uYVkwenThmIxDbw8w==
+-----END CERTIFICATE-----`
+
+	testPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "object-meta",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "any-container",
+					Image: "image",
+				},
+			},
+		},
+	}
+
+	var (
+		i     *Injector
+		store *bundle.Store
+	)
+
+	it.Before(func() {
+		store = bundle.NewStore(nil)
+		require.NoError(t, store.Set(string(bundle.SourceConfig), bundle.SourceConfig, caCertData, ""))
+
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		require.NoError(t, indexer.Add(&nodev1.RuntimeClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "windows-hyperv"},
+			Handler:    "runhcs-wcow-hypervisor",
+			Scheduling: &nodev1.Scheduling{NodeSelector: map[string]string{"kubernetes.io/os": "windows"}},
+		}))
+		require.NoError(t, indexer.Add(&nodev1.RuntimeClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "gvisor"},
+			Handler:    "runsc",
+			Scheduling: &nodev1.Scheduling{NodeSelector: map[string]string{"kubernetes.io/os": "linux"}},
+		}))
+
+		i = New(config.CaCerts{Name: "ca-certs", SetupCaCertsImage: "zezaeoh/setup-ca-certs"}, store, nodelisters.NewRuntimeClassLister(indexer))
+	})
+
+	matchRaw := func(raw []byte) bool {
+		pod := &corev1.Pod{}
+		require.NoError(t, json.Unmarshal(raw, pod))
+		ctx := injector.WithRequest(context.TODO(), &admissionv1.AdmissionRequest{
+			Object: runtime.RawExtension{Raw: raw},
+		})
+
+		ok, err := i.Match(ctx, pod)
+		require.NoError(t, err)
+		return ok
+	}
+	match := func(mutate func(pod *corev1.Pod)) bool {
+		pod := testPod.DeepCopy()
+		mutate(pod)
+		raw, err := json.Marshal(pod)
+		require.NoError(t, err)
+		return matchRaw(raw)
+	}
+
+	when("#Match", func() {
+		requiredAffinity := func(terms ...corev1.NodeSelectorTerm) *corev1.Affinity {
+			return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+			}}
+		}
+		osTerm := func(operator corev1.NodeSelectorOperator, values ...string) corev1.NodeSelectorTerm {
+			return corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: "kubernetes.io/os", Operator: operator, Values: values},
+			}}
+		}
+
+		it("skips every pod while the bundle is empty", func() {
+			i = New(config.CaCerts{Name: "ca-certs", SetupCaCertsImage: "zezaeoh/setup-ca-certs"}, bundle.NewStore(nil), nil)
+			require.False(t, match(func(*corev1.Pod) {}))
+		})
+
+		it("skips pods injected already", func() {
+			require.False(t, match(func(pod *corev1.Pod) {
+				pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{Name: "setup-ca-certs"})
+			}))
+		})
+
+		it("skips pods with a non-linux spec.os.name", func() {
+			pod, err := json.Marshal(testPod)
+			require.NoError(t, err)
+			raw := bytes.Replace(pod, []byte(`"spec":{`), []byte(`"spec":{"os":{"name":"windows"},`), 1)
+			require.NotEqual(t, pod, raw)
+
+			require.False(t, matchRaw(raw))
+		})
+
+		it("skips pods selecting non-linux nodes", func() {
+			require.False(t, match(func(pod *corev1.Pod) {
+				pod.Spec.NodeSelector = map[string]string{"kubernetes.io/os": "windows"}
+			}))
+			require.False(t, match(func(pod *corev1.Pod) {
+				pod.Spec.NodeSelector = map[string]string{"beta.kubernetes.io/os": "windows"}
+			}))
+		})
+
+		it("skips pods whose required node affinity excludes linux nodes", func() {
+			require.False(t, match(func(pod *corev1.Pod) {
+				pod.Spec.Affinity = requiredAffinity(osTerm(corev1.NodeSelectorOpIn, "windows"))
+			}))
+			require.False(t, match(func(pod *corev1.Pod) {
+				pod.Spec.Affinity = requiredAffinity(osTerm(corev1.NodeSelectorOpNotIn, "linux"), osTerm(corev1.NodeSelectorOpIn, "windows"))
+			}))
+		})
+
+		it("matches pods only tolerating windows node taints", func() {
+			require.True(t, match(func(pod *corev1.Pod) {
+				pod.Spec.Tolerations = []corev1.Toleration{
+					{Key: "os", Operator: corev1.TolerationOpEqual, Value: "windows", Effect: corev1.TaintEffectNoSchedule},
+				}
+			}))
+		})
+
+		it("skips pods of runtime classes scheduled on non-linux nodes", func() {
+			require.False(t, match(func(pod *corev1.Pod) {
+				pod.Spec.RuntimeClassName = ptr.String("windows-hyperv")
+			}))
+		})
+
+		it("matches pods which can run on linux nodes", func() {
+			require.True(t, match(func(pod *corev1.Pod) {
+				pod.Spec.NodeSelector = map[string]string{"kubernetes.io/os": "linux"}
+				pod.Spec.RuntimeClassName = ptr.String("gvisor")
+				pod.Spec.Affinity = requiredAffinity(osTerm(corev1.NodeSelectorOpIn, "windows"), osTerm(corev1.NodeSelectorOpIn, "linux"))
+			}))
+		})
+	})
+
+	when("#Inject", func() {
+		var (
+			pod      *corev1.Pod
+			warnings *injector.Warnings
+			ctx      context.Context
+		)
+
+		it.Before(func() {
+			pod = testPod.DeepCopy()
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+				Name:         "own-certs",
+				Image:        "image",
+				VolumeMounts: []corev1.VolumeMount{{Name: "certs", MountPath: "/etc/ssl/certs/"}},
+			})
+			warnings = &injector.Warnings{}
+			ctx = injector.WithWarnings(context.TODO(), warnings)
+		})
+
+		inject := func(conflicts config.CaCertsConflicts) error {
+			i = New(config.CaCerts{Name: "ca-certs", SetupCaCertsImage: "zezaeoh/setup-ca-certs", Conflicts: conflicts}, store, nil)
+			return i.Inject(ctx, pod)
+		}
+		injectFiles := func(mount config.CaCertsMount, conflicts config.CaCertsConflicts) error {
+			mount.Mode = config.MountFiles
+			i = New(config.CaCerts{Name: "ca-certs", SetupCaCertsImage: "zezaeoh/setup-ca-certs", Mount: mount, Conflicts: conflicts}, store, nil)
+			return i.Inject(ctx, pod)
+		}
+
+		it("mounts the CA certs into every container", func() {
+			pod.Spec.Containers = pod.Spec.Containers[:1]
+			require.NoError(t, inject(config.CaCertsConflicts{}))
+
+			assert.Equal(t, []corev1.VolumeMount{{Name: "ca-certs", MountPath: "/etc/ssl/certs", ReadOnly: true}}, pod.Spec.Containers[0].VolumeMounts)
+			assert.Equal(t, "ca-certs", pod.Spec.InitContainers[0].VolumeMounts[0].Name)
+			assert.Empty(t, warnings.List())
+		})
+
+		it("skips the containers already mounting a volume at the trust store", func() {
+			require.NoError(t, inject(config.CaCertsConflicts{}))
+
+			assert.Len(t, pod.Spec.Containers[0].VolumeMounts, 1)
+			assert.Equal(t, []corev1.VolumeMount{{Name: "certs", MountPath: "/etc/ssl/certs/"}}, pod.Spec.Containers[1].VolumeMounts)
+			assert.Equal(t, []string{`knurse: container "own-certs" already mounts volume "certs" at /etc/ssl/certs, it gets no CA certs`}, warnings.List())
+		})
+
+		it("only mounts the bundle into the conflicting containers with the subPath strategy", func() {
+			require.NoError(t, inject(config.CaCertsConflicts{Mount: config.ConflictSubPath}))
+
+			assert.Equal(t, []corev1.VolumeMount{
+				{Name: "certs", MountPath: "/etc/ssl/certs/"},
+				{Name: "ca-certs", MountPath: "/etc/ssl/certs/ca-certificates.crt", SubPath: "ca-certificates.crt", ReadOnly: true},
+			}, pod.Spec.Containers[1].VolumeMounts)
+			assert.Len(t, warnings.List(), 1)
+		})
+
+		it("denies the pod with the subPath strategy when the conflicting volume is read-only", func() {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+				Name:         "certs",
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "certs"}}},
+			})
+
+			err := inject(config.CaCertsConflicts{Mount: config.ConflictSubPath})
+			assert.EqualError(t, err, `container "own-certs" mounts read-only volume "certs" at /etc/ssl/certs, the CA certs cannot be mounted into it`)
+		})
+
+		it("denies the pod with the deny strategy", func() {
+			err := inject(config.CaCertsConflicts{Mount: config.ConflictDeny})
+			assert.EqualError(t, err, `container "own-certs" already mounts volume "certs" at /etc/ssl/certs`)
+		})
+
+		when("the files mount mode is set", func() {
+			it("mounts the bundle alone, next to the mounts of the container", func() {
+				require.NoError(t, injectFiles(config.CaCertsMount{}, config.CaCertsConflicts{}))
+
+				bundle := corev1.VolumeMount{Name: "ca-certs", MountPath: "/etc/ssl/certs/ca-certificates.crt", SubPath: "ca-certificates.crt", ReadOnly: true}
+				assert.Equal(t, []corev1.VolumeMount{bundle}, pod.Spec.Containers[0].VolumeMounts)
+				assert.Equal(t, []corev1.VolumeMount{{Name: "certs", MountPath: "/etc/ssl/certs/"}, bundle}, pod.Spec.Containers[1].VolumeMounts)
+				assert.Nil(t, pod.Spec.InitContainers[0].Args)
+				assert.Empty(t, warnings.List())
+			})
+
+			it("mounts the named files and the hash files of the certificates", func() {
+				require.NoError(t, injectFiles(config.CaCertsMount{
+					Files:     []string{"ca-certificates.crt", "java/cacerts"},
+					HashLinks: true,
+				}, config.CaCertsConflicts{}))
+
+				assert.Equal(t, []corev1.VolumeMount{
+					{Name: "ca-certs", MountPath: "/etc/ssl/certs/ca-certificates.crt", SubPath: "ca-certificates.crt", ReadOnly: true},
+					{Name: "ca-certs", MountPath: "/etc/ssl/certs/java/cacerts", SubPath: "java/cacerts", ReadOnly: true},
+					{Name: "ca-certs", MountPath: "/etc/ssl/certs/1e189625.0", SubPath: "knurse-hashes/1e189625.0", ReadOnly: true},
+				}, pod.Spec.Containers[0].VolumeMounts)
+				assert.Equal(t, []string{"--format", "store,manifest,hashes"}, pod.Spec.InitContainers[0].Args)
+			})
+
+			it("skips the containers mounting a read-only volume at the trust store", func() {
+				pod.Spec.Containers[1].VolumeMounts[0].ReadOnly = true
+				require.NoError(t, injectFiles(config.CaCertsMount{}, config.CaCertsConflicts{}))
+
+				assert.Equal(t, []corev1.VolumeMount{{Name: "certs", MountPath: "/etc/ssl/certs/", ReadOnly: true}}, pod.Spec.Containers[1].VolumeMounts)
+				assert.Equal(t, []string{`knurse: container "own-certs" mounts read-only volume "certs" at /etc/ssl/certs, it gets no CA certs`}, warnings.List())
+			})
+
+			it("skips the files the containers already mount a volume at", func() {
+				pod.Spec.Containers[1].VolumeMounts = []corev1.VolumeMount{{Name: "bundle", MountPath: "/etc/ssl/certs/ca-certificates.crt"}}
+				require.NoError(t, injectFiles(config.CaCertsMount{}, config.CaCertsConflicts{}))
+
+				assert.Len(t, pod.Spec.Containers[1].VolumeMounts, 1)
+				assert.Equal(t, []string{`knurse: container "own-certs" already mounts volume "bundle" at /etc/ssl/certs/ca-certificates.crt, it gets no CA certs there`}, warnings.List())
+			})
+
+			it("denies the pod when a container already mounts a volume at a file with the deny strategy", func() {
+				pod.Spec.Containers[1].VolumeMounts = []corev1.VolumeMount{{Name: "bundle", MountPath: "/etc/ssl/certs/ca-certificates.crt"}}
+
+				err := injectFiles(config.CaCertsMount{}, config.CaCertsConflicts{Mount: config.ConflictDeny})
+				assert.EqualError(t, err, `container "own-certs" already mounts volume "bundle" at /etc/ssl/certs/ca-certificates.crt`)
+			})
+		})
+
+		when("containers are targeted", func() {
+			it.Before(func() {
+				pod.Spec.Containers = []corev1.Container{
+					{Name: "app", Image: "app"},
+					{Name: "worker", Image: "worker"},
+					{Name: "istio-proxy", Image: "docker.io/istio/proxyv2:1.20.0"},
+					{Name: "mesh", Image: "cr.l5d.io/linkerd/proxy:stable-2.14.0"},
+				}
+				i = New(config.CaCerts{Name: "ca-certs", SetupCaCertsImage: "zezaeoh/setup-ca-certs", SkipContainers: config.CaCertsSkipContainers{
+					Names:  config.DefaultSkipContainerNames,
+					Images: config.DefaultSkipContainerImages,
+				}}, store, nil)
+			})
+
+			mounted := func() []string {
+				var names []string
+				for _, c := range pod.Spec.Containers {
+					if len(c.VolumeMounts) > 0 {
+						names = append(names, c.Name)
+					}
+				}
+				return names
+			}
+
+			it("skips the sidecars of the config by name or image", func() {
+				require.NoError(t, i.Inject(ctx, pod))
+
+				assert.Equal(t, []string{"app", "worker"}, mounted())
+			})
+
+			it("skips the sidecars of untagged images", func() {
+				pod.Spec.Containers = []corev1.Container{
+					{Name: "app", Image: "app"},
+					{Name: "envoy", Image: "docker.io/istio/proxyv2"},
+					{Name: "l5d", Image: "cr.l5d.io/linkerd/proxy"},
+					{Name: "l5d-init", Image: "cr.l5d.io/linkerd/proxy-init"},
+				}
+				require.NoError(t, i.Inject(ctx, pod))
+
+				assert.Equal(t, []string{"app"}, mounted())
+			})
+
+			it("only mounts the containers listed by the pod", func() {
+				pod.Annotations = map[string]string{ContainersAnnotation: "worker, istio-proxy,unknown"}
+				require.NoError(t, i.Inject(ctx, pod))
+
+				assert.Equal(t, []string{"worker", "istio-proxy"}, mounted())
+				assert.Equal(t, []string{`knurse: cacerts.knurse.zezaeoh.io/containers lists unknown containers: unknown`}, warnings.List())
+			})
+
+			it("skips the containers excluded by the pod", func() {
+				pod.Annotations = map[string]string{ExcludeContainersAnnotation: "worker"}
+				require.NoError(t, i.Inject(ctx, pod))
+
+				assert.Equal(t, []string{"app"}, mounted())
+			})
+
+			it("does not match pods without targeted containers", func() {
+				pod.Annotations = map[string]string{ExcludeContainersAnnotation: "app,worker"}
+
+				ok, err := i.Match(context.TODO(), pod)
+				require.NoError(t, err)
+				assert.False(t, ok)
+			})
+		})
+
+		when("the pod already has a volume named ca-certs", func() {
+			it.Before(func() {
+				pod.Spec.Volumes = []corev1.Volume{{Name: "ca-certs"}, {Name: "ca-certs-1"}}
+			})
+
+			it("mounts the CA certs from a volume named uniquely", func() {
+				require.NoError(t, inject(config.CaCertsConflicts{}))
+
+				assert.Equal(t, "ca-certs-2", pod.Spec.Volumes[2].Name)
+				assert.Equal(t, "ca-certs-2", pod.Spec.Containers[0].VolumeMounts[0].Name)
+				assert.Equal(t, "ca-certs-2", pod.Spec.InitContainers[0].VolumeMounts[0].Name)
+				assert.Contains(t, warnings.List(), `knurse: the pod already has a volume named "ca-certs", the CA certs are mounted from volume "ca-certs-2"`)
+			})
+
+			it("denies the pod with the deny strategy", func() {
+				err := inject(config.CaCertsConflicts{Volume: config.ConflictDeny})
+				assert.EqualError(t, err, `the pod already has a volume named "ca-certs"`)
+			})
+		})
+	})
+}
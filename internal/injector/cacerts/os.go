@@ -0,0 +1,115 @@
+package cacerts
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+const (
+	osLabel     = "kubernetes.io/os"
+	betaOSLabel = "beta.kubernetes.io/os"
+	linux       = "linux"
+)
+
+// podOS is the part of a pod the vendored API types do not know about yet.
+type podOS struct {
+	Spec struct {
+		OS *struct {
+			Name string `json:"name"`
+		} `json:"os"`
+	} `json:"spec"`
+}
+
+// nonLinux returns why the pod cannot run on a Linux node, if it cannot. The
+// setup-ca-certs image only runs on Linux, injecting it into other pods leaves
+// them stuck in Init. Only the os the pod or its RuntimeClass selects counts,
+// tolerating the taints of Windows nodes lets a pod run on them, not only on them.
+func (i *Injector) nonLinux(raw []byte, pod *corev1.Pod) (string, bool, error) {
+	var os podOS
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &os); err != nil {
+			return "", false, err
+		}
+	}
+	if os.Spec.OS != nil && os.Spec.OS.Name != "" && os.Spec.OS.Name != linux {
+		return fmt.Sprintf("spec.os.name is %q", os.Spec.OS.Name), true, nil
+	}
+
+	if name, ok := nodeSelectorOS(pod.Spec.NodeSelector); ok {
+		return fmt.Sprintf("node selector requires os %q", name), true, nil
+	}
+	if excludesLinux(pod.Spec.Affinity) {
+		return "required node affinity excludes linux nodes", true, nil
+	}
+
+	if name := pod.Spec.RuntimeClassName; name != nil && *name != "" {
+		rc, err := i.runtimeclasslister.Get(*name)
+		if apierrors.IsNotFound(err) {
+			// The RuntimeClass admission plugin rejects the pod.
+			return "", false, nil
+		} else if err != nil {
+			return "", false, err
+		}
+		if rc.Scheduling == nil {
+			return "", false, nil
+		}
+		if os, ok := nodeSelectorOS(rc.Scheduling.NodeSelector); ok {
+			return fmt.Sprintf("runtime class %q requires os %q", *name, os), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// nodeSelectorOS returns the non-Linux os a node selector requires.
+func nodeSelectorOS(selector map[string]string) (string, bool) {
+	for _, key := range []string{osLabel, betaOSLabel} {
+		if os, ok := selector[key]; ok && os != linux {
+			return os, true
+		}
+	}
+	return "", false
+}
+
+// excludesLinux returns whether no term of the required node affinity selects Linux nodes.
+func excludesLinux(affinity *corev1.Affinity) bool {
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return false
+	}
+	for _, term := range terms {
+		if !termExcludesLinux(term) {
+			return false
+		}
+	}
+	return true
+}
+
+func termExcludesLinux(term corev1.NodeSelectorTerm) bool {
+	for _, expr := range term.MatchExpressions {
+		if expr.Key != osLabel && expr.Key != betaOSLabel {
+			continue
+		}
+		switch expr.Operator {
+		case corev1.NodeSelectorOpIn:
+			if !injector.Contains(expr.Values, linux) {
+				return true
+			}
+		case corev1.NodeSelectorOpNotIn:
+			if injector.Contains(expr.Values, linux) {
+				return true
+			}
+		case corev1.NodeSelectorOpDoesNotExist:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package cacerts
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+const (
+	// ContainersAnnotation lists the only containers of the pod the CA certs
+	// are mounted into, comma separated, e.g. app,worker. It overrides the
+	// skipped containers of the config.
+	ContainersAnnotation = "cacerts.knurse.zezaeoh.io/containers"
+	// ExcludeContainersAnnotation lists the containers of the pod the CA certs
+	// are not mounted into, comma separated.
+	ExcludeContainersAnnotation = "cacerts.knurse.zezaeoh.io/exclude-containers"
+)
+
+// targets selects the containers of a pod the CA certs are mounted into.
+type targets struct {
+	// include is nil when the pod does not list its containers.
+	include map[string]struct{}
+	exclude map[string]struct{}
+	skip    config.CaCertsSkipContainers
+}
+
+func (i *Injector) targets(pod *corev1.Pod) targets {
+	t := targets{
+		exclude: nameSet(pod.Annotations[ExcludeContainersAnnotation]),
+		skip:    i.skip,
+	}
+	if value, ok := pod.Annotations[ContainersAnnotation]; ok {
+		t.include = nameSet(value)
+	}
+	return t
+}
+
+// selects returns whether the CA certs are mounted into the container.
+func (t targets) selects(c *corev1.Container) bool {
+	if _, ok := t.exclude[c.Name]; ok {
+		return false
+	}
+	if t.include != nil {
+		_, ok := t.include[c.Name]
+		return ok
+	}
+	for _, name := range t.skip.Names {
+		if c.Name == name {
+			return false
+		}
+	}
+	for _, pattern := range t.skip.Images {
+		if matchPattern(pattern, c.Image) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectsAny returns whether the CA certs are mounted into any container of the pod.
+func (t targets) selectsAny(pod *corev1.Pod) bool {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for j := range containers {
+			if t.selects(&containers[j]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// missing returns the containers the pod lists which it does not have.
+func (t targets) missing(pod *corev1.Pod) []string {
+	var missing []string
+	for name := range t.include {
+		if !injector.HasContainer(pod, name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func nameSet(value string) map[string]struct{} {
+	names := map[string]struct{}{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = struct{}{}
+		}
+	}
+	return names
+}
+
+// matchPattern returns whether s matches pattern, in which * matches any
+// sequence of characters.
+func matchPattern(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,51 @@
+package injector
+
+import (
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Container returns the init container or container of the pod named name, if any.
+func Container(pod *corev1.Pod, name string) *corev1.Container {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			if containers[i].Name == name {
+				return &containers[i]
+			}
+		}
+	}
+	return nil
+}
+
+// HasContainer returns whether the pod has an init container or container named name.
+func HasContainer(pod *corev1.Pod, name string) bool {
+	return Container(pod, name) != nil
+}
+
+// MountAt returns the mount of the container at mountPath, if any. Paths are
+// compared cleaned, "/etc/ssl/certs/" is mounted at "/etc/ssl/certs".
+func MountAt(c *corev1.Container, mountPath string) *corev1.VolumeMount {
+	mountPath = path.Clean(mountPath)
+	for i := range c.VolumeMounts {
+		if path.Clean(c.VolumeMounts[i].MountPath) == mountPath {
+			return &c.VolumeMounts[i]
+		}
+	}
+	return nil
+}
+
+// Mounts returns whether the container mounts a volume at mountPath.
+func Mounts(c *corev1.Container, mountPath string) bool {
+	return MountAt(c, mountPath) != nil
+}
+
+// Contains returns whether values contains value.
+func Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
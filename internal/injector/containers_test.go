@@ -0,0 +1,71 @@
+package injector
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestContainers(t *testing.T) {
+	spec.Run(t, "Containers", testContainers)
+}
+
+func testContainers(t *testing.T, when spec.G, it spec.S) {
+	var pod *corev1.Pod
+
+	it.Before(func() {
+		pod = &corev1.Pod{
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init"}},
+				Containers: []corev1.Container{{
+					Name: "app",
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "certs", MountPath: "/etc/ssl/certs/"},
+					},
+				}},
+			},
+		}
+	})
+
+	when("#Container", func() {
+		it("finds init containers and containers", func() {
+			require.NotNil(t, Container(pod, "init"))
+			c := Container(pod, "app")
+			require.NotNil(t, c)
+
+			c.Image = "app"
+			assert.Equal(t, "app", pod.Spec.Containers[0].Image)
+			assert.True(t, HasContainer(pod, "init"))
+		})
+
+		it("returns nil for unknown containers", func() {
+			assert.Nil(t, Container(pod, "other"))
+			assert.False(t, HasContainer(pod, "other"))
+		})
+	})
+
+	when("#MountAt", func() {
+		it("compares cleaned paths", func() {
+			m := MountAt(&pod.Spec.Containers[0], "/etc/ssl/certs")
+			require.NotNil(t, m)
+			assert.Equal(t, "certs", m.Name)
+			assert.True(t, Mounts(&pod.Spec.Containers[0], "/etc/ssl//certs/"))
+		})
+
+		it("returns nil for other paths", func() {
+			assert.Nil(t, MountAt(&pod.Spec.Containers[0], "/etc/ssl"))
+			assert.False(t, Mounts(&pod.Spec.InitContainers[0], "/etc/ssl/certs"))
+		})
+	})
+
+	when("#Contains", func() {
+		it("returns whether the value is listed", func() {
+			assert.True(t, Contains([]string{"a", "b"}, "b"))
+			assert.False(t, Contains([]string{"a", "b"}, "c"))
+			assert.False(t, Contains(nil, "a"))
+		})
+	})
+}
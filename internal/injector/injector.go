@@ -0,0 +1,85 @@
+package injector
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/zezaeoh/knurse/internal/config"
+)
+
+// Injector mutates the pods admitted by knurse. The injectors enabled in the
+// config run in order on the same pod, their changes are returned as a single
+// JSON patch.
+type Injector interface {
+	// Name identifies the injector in the config.
+	Name() string
+	// Match returns whether the injector applies to the pod. It is called with
+	// the pod as mutated by the injectors running before it.
+	Match(ctx context.Context, pod *corev1.Pod) (bool, error)
+	// Inject mutates the pod.
+	Inject(ctx context.Context, pod *corev1.Pod) error
+}
+
+// EphemeralInjector is implemented by the injectors also mutating the
+// ephemeral containers added to running pods through the
+// pods/ephemeralcontainers subresource.
+type EphemeralInjector interface {
+	Injector
+	// InjectEphemeral mutates the ephemeral containers of the pod, the rest of
+	// the pod cannot be changed.
+	InjectEphemeral(ctx context.Context, pod *corev1.Pod) error
+}
+
+// Factory constructs an injector from the config. ctx is the injection context
+// of the webhook, informers may be taken from it.
+type Factory func(ctx context.Context, cfg *config.Config, ic config.InjectorConfig) (Injector, error)
+
+// Registry holds the factories of the available injectors by name.
+type Registry struct {
+	factories map[string]Factory
+}
+
+func NewRegistry() *Registry {
+	return &Registry{factories: map[string]Factory{}}
+}
+
+// Register makes the injector name available to the config.
+func (r *Registry) Register(name string, factory Factory) {
+	if _, ok := r.factories[name]; ok {
+		panic("injector " + name + " is already registered")
+	}
+	r.factories[name] = factory
+}
+
+// Build constructs the injectors enabled in the config, in order.
+func (r *Registry) Build(ctx context.Context, cfg *config.Config) ([]Injector, error) {
+	var injectors []Injector
+	for _, ic := range cfg.EnabledInjectors() {
+		factory, ok := r.factories[ic.Name]
+		if !ok {
+			return nil, errors.Errorf("injector %q is not registered", ic.Name)
+		}
+		inj, err := factory(ctx, cfg, ic)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to construct injector %q", ic.Name)
+		}
+		injectors = append(injectors, inj)
+	}
+	return injectors, nil
+}
+
+type requestKey struct{}
+
+// WithRequest attaches the admission request of the pod to ctx.
+func WithRequest(ctx context.Context, req *admissionv1.AdmissionRequest) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// GetRequest returns the admission request of the pod being injected, if any.
+func GetRequest(ctx context.Context) *admissionv1.AdmissionRequest {
+	req, _ := ctx.Value(requestKey{}).(*admissionv1.AdmissionRequest)
+	return req
+}
@@ -0,0 +1,172 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+	"knative.dev/pkg/logging"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+const (
+	// Annotation opts a pod in or out of the image rewriting.
+	Annotation = "mirror.knurse.zezaeoh.io/inject"
+	// OriginalImagesAnnotation records the original image of every rewritten
+	// container, as a JSON object keyed by container name.
+	OriginalImagesAnnotation = "mirror.knurse.zezaeoh.io/original-images"
+
+	defaultRegistry = "docker.io"
+)
+
+// Injector rewrites the image references of the containers to mirrors.
+type Injector struct {
+	selector  *injector.Selector
+	rules     []config.MirrorRule
+	allowlist map[string]struct{}
+}
+
+// New constructs the mirror injector.
+func New(cfg config.Mirror, namespacelister corelisters.NamespaceLister) (*Injector, error) {
+	selector, err := injector.NewSelector(cfg.Selection, Annotation, namespacelister)
+	if err != nil {
+		return nil, err
+	}
+	allowlist := map[string]struct{}{}
+	for _, registry := range cfg.Allowlist {
+		allowlist[registry] = struct{}{}
+	}
+	return &Injector{
+		selector:  selector,
+		rules:     cfg.Rules,
+		allowlist: allowlist,
+	}, nil
+}
+
+// NewFactory returns the factory of the mirror injector.
+func NewFactory() injector.Factory {
+	return func(ctx context.Context, cfg *config.Config, _ config.InjectorConfig) (injector.Injector, error) {
+		return New(cfg.Webhook.Mirror, namespaceinformer.Get(ctx).Lister())
+	}
+}
+
+// Name implements injector.Injector
+func (i *Injector) Name() string {
+	return config.MirrorInjector
+}
+
+// Match implements injector.Injector
+func (i *Injector) Match(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	return i.selector.Selects(ctx, pod)
+}
+
+// Inject implements injector.Injector
+func (i *Injector) Inject(_ context.Context, pod *corev1.Pod) error {
+	originals := map[string]string{}
+	if recorded, ok := pod.Annotations[OriginalImagesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(recorded), &originals); err != nil {
+			return errors.Wrapf(err, "invalid %s annotation", OriginalImagesAnnotation)
+		}
+	}
+
+	rewritten := false
+	for c := range pod.Spec.InitContainers {
+		rewritten = i.rewrite(pod.Spec.InitContainers[c].Name, &pod.Spec.InitContainers[c].Image, originals) || rewritten
+	}
+	for c := range pod.Spec.Containers {
+		rewritten = i.rewrite(pod.Spec.Containers[c].Name, &pod.Spec.Containers[c].Image, originals) || rewritten
+	}
+	for c := range pod.Spec.EphemeralContainers {
+		rewritten = i.rewrite(pod.Spec.EphemeralContainers[c].Name, &pod.Spec.EphemeralContainers[c].Image, originals) || rewritten
+	}
+	if !rewritten {
+		return nil
+	}
+
+	b, err := json.Marshal(originals)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[OriginalImagesAnnotation] = string(b)
+	return nil
+}
+
+// InjectEphemeral implements injector.EphemeralInjector. The ephemeral
+// containers of the pod before the update are left alone, they are immutable.
+// The original images are only logged, the annotations of the pod cannot be
+// changed through the subresource.
+func (i *Injector) InjectEphemeral(ctx context.Context, pod *corev1.Pod) error {
+	existing := map[string]struct{}{}
+	if req := injector.GetRequest(ctx); req != nil && len(req.OldObject.Raw) > 0 {
+		old := corev1.Pod{}
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return err
+		}
+		for _, c := range old.Spec.EphemeralContainers {
+			existing[c.Name] = struct{}{}
+		}
+	}
+
+	originals := map[string]string{}
+	for c := range pod.Spec.EphemeralContainers {
+		container := &pod.Spec.EphemeralContainers[c]
+		if _, ok := existing[container.Name]; ok {
+			continue
+		}
+		i.rewrite(container.Name, &container.Image, originals)
+	}
+	for name, original := range originals {
+		logging.FromContext(ctx).Infof("Rewrote image %q of ephemeral container %q", original, name)
+	}
+	return nil
+}
+
+// rewrite rewrites image with the first matching rule, recording the original
+// reference in originals.
+func (i *Injector) rewrite(name string, image *string, originals map[string]string) bool {
+	ref := normalize(*image)
+	if _, ok := i.allowlist[registry(ref)]; ok {
+		return false
+	}
+	for r := range i.rules {
+		if rewritten, ok := i.rules[r].Rewrite(ref); ok {
+			if rewritten == *image {
+				return false
+			}
+			originals[name] = *image
+			*image = rewritten
+			return true
+		}
+	}
+	return false
+}
+
+// normalize fully qualifies an image reference, e.g. nginx:1.21 as
+// docker.io/library/nginx:1.21.
+func normalize(image string) string {
+	i := strings.IndexRune(image, '/')
+	if i == -1 || !strings.ContainsAny(image[:i], ".:") && image[:i] != "localhost" {
+		image = defaultRegistry + "/" + image
+	} else if image[:i] == "index.docker.io" {
+		image = defaultRegistry + image[i:]
+	}
+
+	if repository := strings.TrimPrefix(image, defaultRegistry+"/"); repository != image && !strings.ContainsRune(repository, '/') {
+		image = defaultRegistry + "/library/" + repository
+	}
+	return image
+}
+
+// registry returns the registry of a normalized image reference.
+func registry(ref string) string {
+	return ref[:strings.IndexRune(ref, '/')]
+}
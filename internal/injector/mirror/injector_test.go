@@ -0,0 +1,132 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+	"gopkg.in/yaml.v3"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestInjector(t *testing.T) {
+	spec.Run(t, "Injector", testInjector)
+}
+
+func testInjector(t *testing.T, when spec.G, it spec.S) {
+	var i *Injector
+
+	it.Before(func() {
+		var cfg config.Mirror
+		require.NoError(t, yaml.Unmarshal([]byte(`
+rules:
+  - prefix: docker.io/
+    replacement: mirror.corp/docker.io/
+  - regex: ^(ghcr|quay)\.io/(.*)$
+    replacement: mirror.corp/\1/\2
+allowlist:
+  - registry.corp
+`), &cfg))
+		listers := wtesting.NewListers(nil)
+		var err error
+		i, err = New(cfg, listers.GetNamespaceLister())
+		require.NoError(t, err)
+	})
+
+	when("#Inject", func() {
+		it("rewrites the images of every container and records the original ones", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "some-pod"},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{Name: "setup-ca-certs", Image: "zezaeoh/setup-ca-certs:0.1.0"},
+					},
+					Containers: []corev1.Container{
+						{Name: "app", Image: "nginx:1.21"},
+						{Name: "exporter", Image: "quay.io/prometheus/nginx-exporter@sha256:abc"},
+						{Name: "corp", Image: "registry.corp/app:1.0"},
+						{Name: "gcr", Image: "gcr.io/distroless/static"},
+					},
+					EphemeralContainers: []corev1.EphemeralContainer{{
+						EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", Image: "ghcr.io/corp/debug"},
+					}},
+				},
+			}
+			require.NoError(t, i.Inject(context.TODO(), pod))
+
+			assert.Equal(t, "mirror.corp/docker.io/zezaeoh/setup-ca-certs:0.1.0", pod.Spec.InitContainers[0].Image)
+			assert.Equal(t, "mirror.corp/docker.io/library/nginx:1.21", pod.Spec.Containers[0].Image)
+			assert.Equal(t, "mirror.corp/quay/prometheus/nginx-exporter@sha256:abc", pod.Spec.Containers[1].Image)
+			assert.Equal(t, "registry.corp/app:1.0", pod.Spec.Containers[2].Image)
+			assert.Equal(t, "gcr.io/distroless/static", pod.Spec.Containers[3].Image)
+			assert.Equal(t, "mirror.corp/ghcr/corp/debug", pod.Spec.EphemeralContainers[0].Image)
+
+			var originals map[string]string
+			require.NoError(t, json.Unmarshal([]byte(pod.Annotations[OriginalImagesAnnotation]), &originals))
+			assert.Equal(t, map[string]string{
+				"setup-ca-certs": "zezaeoh/setup-ca-certs:0.1.0",
+				"app":            "nginx:1.21",
+				"exporter":       "quay.io/prometheus/nginx-exporter@sha256:abc",
+				"debug":          "ghcr.io/corp/debug",
+			}, originals)
+		})
+
+		it("leaves pods without matching images alone", func() {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "registry.corp/app:1.0"}},
+			}}
+			require.NoError(t, i.Inject(context.TODO(), pod))
+			assert.Empty(t, pod.Annotations)
+		})
+	})
+
+	when("#InjectEphemeral", func() {
+		it("only rewrites the added ephemeral containers", func() {
+			ephemeral := func(name, image string) corev1.EphemeralContainer {
+				return corev1.EphemeralContainer{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: name, Image: image}}
+			}
+			old := &corev1.Pod{Spec: corev1.PodSpec{
+				Containers:          []corev1.Container{{Name: "app", Image: "nginx"}},
+				EphemeralContainers: []corev1.EphemeralContainer{ephemeral("debug-1", "busybox")},
+			}}
+			raw, err := json.Marshal(old)
+			require.NoError(t, err)
+
+			pod := old.DeepCopy()
+			pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, ephemeral("debug-2", "busybox"))
+			ctx := injector.WithRequest(context.TODO(), &admissionv1.AdmissionRequest{
+				OldObject: runtime.RawExtension{Raw: raw},
+			})
+			require.NoError(t, i.InjectEphemeral(ctx, pod))
+
+			assert.Equal(t, "nginx", pod.Spec.Containers[0].Image)
+			assert.Equal(t, "busybox", pod.Spec.EphemeralContainers[0].Image)
+			assert.Equal(t, "mirror.corp/docker.io/library/busybox", pod.Spec.EphemeralContainers[1].Image)
+			assert.Empty(t, pod.Annotations)
+		})
+	})
+}
+
+func TestNormalize(t *testing.T) {
+	for image, expected := range map[string]string{
+		"nginx":                         "docker.io/library/nginx",
+		"nginx:1.21":                    "docker.io/library/nginx:1.21",
+		"zezaeoh/setup-ca-certs":        "docker.io/zezaeoh/setup-ca-certs",
+		"docker.io/nginx":               "docker.io/library/nginx",
+		"index.docker.io/library/nginx": "docker.io/library/nginx",
+		"localhost/app":                 "localhost/app",
+		"registry.corp:5000/app":        "registry.corp:5000/app",
+		"ghcr.io/corp/app@sha256:abc":   "ghcr.io/corp/app@sha256:abc",
+	} {
+		assert.Equal(t, expected, normalize(image), image)
+	}
+}
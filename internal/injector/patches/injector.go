@@ -0,0 +1,341 @@
+package patches
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+	"knative.dev/pkg/logging"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+// Annotation opts a pod in or out of the patch policies.
+const Annotation = "patches.knurse.zezaeoh.io/inject"
+
+// Injector applies the patch policies selecting a pod, in order.
+type Injector struct {
+	policies        []*policy
+	namespacelister corelisters.NamespaceLister
+}
+
+type policy struct {
+	name                string
+	selector            *injector.Selector
+	objectSelector      labels.Selector
+	jsonPatch           *injector.Template
+	strategicMergePatch *injector.Template
+}
+
+// Data is the data the patches are executed over.
+type Data struct {
+	Pod       map[string]interface{}
+	Namespace map[string]interface{}
+}
+
+// New constructs the patches injector, parsing the patches of the policies.
+func New(policies []config.PatchPolicy, namespacelister corelisters.NamespaceLister) (*Injector, error) {
+	i := &Injector{namespacelister: namespacelister}
+	for _, p := range policies {
+		selector, err := injector.NewSelector(p.Selection, Annotation, namespacelister)
+		if err != nil {
+			return nil, errors.Wrapf(err, "patch policy %q", p.Name)
+		}
+		objectSelector, err := metav1.LabelSelectorAsSelector(p.ObjectSelector.AsLabelSelector())
+		if err != nil {
+			return nil, errors.Wrapf(err, "patch policy %q", p.Name)
+		}
+		jsonPatch, err := parse(p.Name+".jsonPatch", &p.JSONPatch)
+		if err != nil {
+			return nil, errors.Wrapf(err, "patch policy %q", p.Name)
+		}
+		strategicMergePatch, err := parse(p.Name+".strategicMergePatch", &p.StrategicMergePatch)
+		if err != nil {
+			return nil, errors.Wrapf(err, "patch policy %q", p.Name)
+		}
+		i.policies = append(i.policies, &policy{
+			name:                p.Name,
+			selector:            selector,
+			objectSelector:      objectSelector,
+			jsonPatch:           jsonPatch,
+			strategicMergePatch: strategicMergePatch,
+		})
+	}
+	return i, nil
+}
+
+// NewFactory returns the factory of the patches injector.
+func NewFactory() injector.Factory {
+	return func(ctx context.Context, cfg *config.Config, _ config.InjectorConfig) (injector.Injector, error) {
+		return New(cfg.Webhook.Patches.Policies, namespaceinformer.Get(ctx).Lister())
+	}
+}
+
+// parse parses the patch as a template, nil when it is unset.
+func parse(name string, patch *yaml.Node) (*injector.Template, error) {
+	if patch.Kind == 0 {
+		return nil, nil
+	}
+	return injector.NewTemplate(name, patch)
+}
+
+// Name implements injector.Injector
+func (i *Injector) Name() string {
+	return config.PatchesInjector
+}
+
+// Match implements injector.Injector
+func (i *Injector) Match(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	for _, p := range i.policies {
+		if ok, err := p.selects(ctx, pod); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// Inject implements injector.Injector
+func (i *Injector) Inject(ctx context.Context, pod *corev1.Pod) error {
+	namespace, err := i.namespace(injector.Namespace(ctx, pod))
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(pod)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range i.policies {
+		current := &corev1.Pod{}
+		if err := json.Unmarshal(doc, current); err != nil {
+			return err
+		}
+		if ok, err := p.selects(ctx, current); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		data := Data{Namespace: namespace}
+		if err := json.Unmarshal(doc, &data.Pod); err != nil {
+			return err
+		}
+		patched, err := p.apply(doc, data)
+		if errors.Is(err, errTestFailed) {
+			logging.FromContext(ctx).Debugf("Skipping patch policy %q: %v", p.name, err)
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "patch policy %q", p.name)
+		}
+		if err := roundTrips(patched); err != nil {
+			return errors.Wrapf(err, "patch policy %q", p.name)
+		}
+		doc = patched
+	}
+
+	patched := corev1.Pod{}
+	if err := json.Unmarshal(doc, &patched); err != nil {
+		return err
+	}
+	*pod = patched
+	return nil
+}
+
+// namespace returns the JSON representation of the namespace, empty when it does not exist.
+func (i *Injector) namespace(name string) (map[string]interface{}, error) {
+	namespace := map[string]interface{}{}
+	ns, err := i.namespacelister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return namespace, nil
+	} else if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(ns)
+	if err != nil {
+		return nil, err
+	}
+	return namespace, json.Unmarshal(b, &namespace)
+}
+
+func (p *policy) selects(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	if !p.objectSelector.Matches(labels.Set(pod.Labels)) {
+		return false, nil
+	}
+	return p.selector.Selects(ctx, pod)
+}
+
+var errTestFailed = errors.New("test operation failed")
+
+// apply applies the patches of the policy to doc.
+func (p *policy) apply(doc []byte, data Data) ([]byte, error) {
+	if p.jsonPatch != nil {
+		b, err := p.jsonPatch.Execute(data)
+		if err != nil {
+			return nil, err
+		}
+		var operations []map[string]interface{}
+		if err := json.Unmarshal(b, &operations); err != nil {
+			return nil, errors.Wrap(err, "jsonPatch")
+		}
+		for n, operation := range operations {
+			if doc, err = applyOperation(doc, operation); err != nil {
+				return nil, errors.Wrapf(err, "jsonPatch[%d]", n)
+			}
+		}
+	}
+
+	if p.strategicMergePatch != nil {
+		b, err := p.strategicMergePatch.Execute(data)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = strategicpatch.StrategicMergePatch(doc, b, corev1.Pod{}); err != nil {
+			return nil, errors.Wrap(err, "strategicMergePatch")
+		}
+	}
+	return doc, nil
+}
+
+func applyOperation(doc []byte, operation map[string]interface{}) ([]byte, error) {
+	path, _ := operation["path"].(string)
+	if ifAbsent, _ := operation["ifAbsent"].(bool); ifAbsent {
+		delete(operation, "ifAbsent")
+		if ok, err := exists(doc, path); err != nil || ok {
+			return doc, err
+		}
+	}
+
+	b, err := json.Marshal([]map[string]interface{}{operation})
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.DecodePatch(b)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := patch.Apply(doc)
+	if err != nil && operation["op"] == "test" {
+		return nil, errors.Wrapf(errTestFailed, "%s", path)
+	}
+	return patched, err
+}
+
+// roundTrips fails when doc sets fields which the Kubernetes API types knurse
+// is built with do not know. The mutated pod is patched from these types, so
+// the fields would be silently dropped.
+func roundTrips(doc []byte) error {
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(doc, pod); err != nil {
+		return err
+	}
+	b, err := json.Marshal(pod)
+	if err != nil {
+		return err
+	}
+	var before, after interface{}
+	if err := json.Unmarshal(doc, &before); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &after); err != nil {
+		return err
+	}
+	if path, ok := dropped(before, after, ""); ok {
+		return errors.Errorf("%s: unknown field, it cannot be patched", path)
+	}
+	return nil
+}
+
+// dropped returns the path of the first non-zero value of before missing from after.
+func dropped(before, after interface{}, path string) (string, bool) {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, _ := after.(map[string]interface{})
+		for key, value := range b {
+			field := path + "/" + strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+			if _, ok := a[key]; !ok {
+				if !isZero(value) {
+					return field, true
+				}
+				continue
+			}
+			if p, ok := dropped(value, a[key], field); ok {
+				return p, true
+			}
+		}
+	case []interface{}:
+		a, _ := after.([]interface{})
+		for n := 0; n < len(b) && n < len(a); n++ {
+			if p, ok := dropped(b[n], a[n], path+"/"+strconv.Itoa(n)); ok {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isZero returns whether value is omitted by the API types, e.g. false or {}.
+func isZero(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case map[string]interface{}:
+		for _, elem := range v {
+			if !isZero(elem) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// exists returns whether the JSON pointer path points to a value of doc.
+func exists(doc []byte, path string) (bool, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return false, err
+	}
+	if path == "" {
+		return true, nil
+	}
+
+	for _, token := range strings.Split(path, "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[token]; !ok {
+				return false, nil
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return false, nil
+			}
+			v = node[i]
+		default:
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,246 @@
+package patches
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+	"gopkg.in/yaml.v3"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestInjector(t *testing.T) {
+	spec.Run(t, "Injector", testInjector)
+}
+
+func testInjector(t *testing.T, when spec.G, it spec.S) {
+	var (
+		pod *corev1.Pod
+		ctx context.Context
+	)
+
+	it.Before(func() {
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-pod", Labels: map[string]string{"app": "shop"}},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "app"}},
+			},
+		}
+		ctx = injector.WithRequest(context.TODO(), &admissionv1.AdmissionRequest{Namespace: "shop"})
+	})
+
+	newInjector := func(policies string) *Injector {
+		var cfg config.Patches
+		require.NoError(t, yaml.Unmarshal([]byte(policies), &cfg))
+
+		listers := wtesting.NewListers([]runtime.Object{&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"team": "payments"}},
+		}})
+		i, err := New(cfg.Policies, listers.GetNamespaceLister())
+		require.NoError(t, err)
+		return i
+	}
+
+	when("#Match", func() {
+		it("matches when a policy selects the pod", func() {
+			i := newInjector(`
+policies:
+  - name: other
+    objectSelector:
+      matchLabels:
+        app: other
+    jsonPatch: []
+  - name: shop
+    objectSelector:
+      matchLabels:
+        app: shop
+    jsonPatch: []
+`)
+			ok, err := i.Match(ctx, pod)
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			pod.Labels["app"] = "unknown"
+			ok, err = i.Match(ctx, pod)
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	})
+
+	when("#Inject", func() {
+		it("applies the templated json patches", func() {
+			i := newInjector(`
+policies:
+  - name: labels
+    jsonPatch:
+      - op: add
+        path: /metadata/labels/team
+        value: '{{ .Namespace.metadata.labels.team }}'
+      - op: add
+        path: /spec/containers/0/env
+        value:
+          - name: POD_NAME
+            value: '{{ .Pod.metadata.name }}'
+`)
+			require.NoError(t, i.Inject(ctx, pod))
+
+			assert.Equal(t, "payments", pod.Labels["team"])
+			assert.Equal(t, []corev1.EnvVar{{Name: "POD_NAME", Value: "some-pod"}}, pod.Spec.Containers[0].Env)
+		})
+
+		it("skips the operations with ifAbsent when the path exists", func() {
+			i := newInjector(`
+policies:
+  - name: labels
+    jsonPatch:
+      - op: add
+        path: /metadata/labels/app
+        value: overridden
+        ifAbsent: true
+      - op: add
+        path: /metadata/labels/tier
+        value: web
+        ifAbsent: true
+`)
+			require.NoError(t, i.Inject(ctx, pod))
+
+			assert.Equal(t, map[string]string{"app": "shop", "tier": "web"}, pod.Labels)
+		})
+
+		it("skips the whole policy when a test operation fails", func() {
+			i := newInjector(`
+policies:
+  - name: guarded
+    jsonPatch:
+      - op: add
+        path: /metadata/labels/first
+        value: "true"
+      - op: test
+        path: /metadata/labels/app
+        value: other
+      - op: add
+        path: /metadata/labels/second
+        value: "true"
+  - name: next
+    jsonPatch:
+      - op: add
+        path: /metadata/labels/next
+        value: "true"
+`)
+			require.NoError(t, i.Inject(ctx, pod))
+
+			assert.Equal(t, map[string]string{"app": "shop", "next": "true"}, pod.Labels)
+		})
+
+		it("applies the strategic merge patches", func() {
+			i := newInjector(`
+policies:
+  - name: resources
+    strategicMergePatch:
+      spec:
+        containers:
+          - name: app
+            resources:
+              limits:
+                memory: 256Mi
+`)
+			require.NoError(t, i.Inject(ctx, pod))
+
+			require.Len(t, pod.Spec.Containers, 1)
+			assert.Equal(t, "app", pod.Spec.Containers[0].Image)
+			assert.Equal(t, resource.MustParse("256Mi"), pod.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory])
+		})
+
+		it("applies only the policies selecting the pod", func() {
+			i := newInjector(`
+policies:
+  - name: other
+    objectSelector:
+      matchLabels:
+        app: other
+    jsonPatch:
+      - op: add
+        path: /metadata/labels/other
+        value: "true"
+  - name: payments
+    namespaceSelector:
+      matchLabels:
+        team: payments
+    jsonPatch:
+      - op: add
+        path: /metadata/labels/payments
+        value: "true"
+`)
+			require.NoError(t, i.Inject(ctx, pod))
+
+			assert.Equal(t, map[string]string{"app": "shop", "payments": "true"}, pod.Labels)
+		})
+
+		it("does not let the pod change the structure of the patches", func() {
+			pod.Annotations = map[string]string{"team": "payments\n  privileged: true"}
+			i := newInjector(`
+policies:
+  - name: team
+    strategicMergePatch:
+      metadata:
+        labels:
+          team: '{{ .Pod.metadata.annotations.team }}'
+`)
+			require.NoError(t, i.Inject(ctx, pod))
+
+			assert.Equal(t, map[string]string{"app": "shop", "team": "payments\n  privileged: true"}, pod.Labels)
+		})
+
+		it("fails when a patch sets fields unknown to knurse", func() {
+			i := newInjector(`
+policies:
+  - name: native-sidecar
+    jsonPatch:
+      - op: add
+        path: /spec/containers/0/restartPolicy
+        value: Always
+`)
+			require.EqualError(t, i.Inject(ctx, pod),
+				`patch policy "native-sidecar": /spec/containers/0/restartPolicy: unknown field, it cannot be patched`)
+		})
+
+		it("accepts patches setting the zero values the API types omit", func() {
+			i := newInjector(`
+policies:
+  - name: defaults
+    strategicMergePatch:
+      spec:
+        hostNetwork: false
+        containers:
+          - name: app
+            resources:
+              limits:
+                cpu: 1000m
+`)
+			require.NoError(t, i.Inject(ctx, pod))
+			limit := pod.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU]
+			assert.Equal(t, "1", limit.String())
+		})
+
+		it("fails when an operation cannot be applied", func() {
+			i := newInjector(`
+policies:
+  - name: broken
+    jsonPatch:
+      - op: replace
+        path: /metadata/annotations/missing
+        value: "true"
+`)
+			assert.Error(t, i.Inject(ctx, pod))
+		})
+	})
+}
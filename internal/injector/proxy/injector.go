@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+// Annotation opts a pod in or out of the proxy injection.
+const Annotation = "proxy.knurse.zezaeoh.io/inject"
+
+// Injector sets the proxy environment variables, in upper and lower case, on
+// every container not setting them already in env. They take precedence over
+// the ones of envFrom.
+type Injector struct {
+	selector *injector.Selector
+	env      []corev1.EnvVar
+}
+
+// New constructs the proxy injector.
+func New(cfg config.Proxy, namespacelister corelisters.NamespaceLister) (*Injector, error) {
+	selector, err := injector.NewSelector(cfg.Selection, Annotation, namespacelister)
+	if err != nil {
+		return nil, err
+	}
+
+	var env []corev1.EnvVar
+	for _, v := range []struct{ name, value string }{
+		{"HTTP_PROXY", cfg.HTTPProxy},
+		{"HTTPS_PROXY", cfg.HTTPSProxy},
+		{"NO_PROXY", noProxy(cfg)},
+	} {
+		if v.value == "" {
+			continue
+		}
+		env = append(env,
+			corev1.EnvVar{Name: v.name, Value: v.value},
+			corev1.EnvVar{Name: strings.ToLower(v.name), Value: v.value},
+		)
+	}
+	return &Injector{selector: selector, env: env}, nil
+}
+
+// NewFactory returns the factory of the proxy injector.
+func NewFactory() injector.Factory {
+	return func(ctx context.Context, cfg *config.Config, _ config.InjectorConfig) (injector.Injector, error) {
+		return New(cfg.Webhook.Proxy, namespaceinformer.Get(ctx).Lister())
+	}
+}
+
+// noProxy joins the hosts reached without the proxy, the cluster ones included.
+func noProxy(cfg config.Proxy) string {
+	clusterDomain := cfg.ClusterDomain
+	if clusterDomain == "" {
+		clusterDomain = config.DefaultClusterDomain
+	}
+
+	var hosts []string
+	seen := map[string]struct{}{}
+	for _, lists := range [][]string{cfg.NoProxy, cfg.ServiceCIDRs, {".svc", "." + strings.TrimPrefix(clusterDomain, ".")}} {
+		for _, host := range lists {
+			if _, ok := seen[host]; ok {
+				continue
+			}
+			seen[host] = struct{}{}
+			hosts = append(hosts, host)
+		}
+	}
+	return strings.Join(hosts, ",")
+}
+
+// Name implements injector.Injector
+func (i *Injector) Name() string {
+	return config.ProxyInjector
+}
+
+// Match implements injector.Injector
+func (i *Injector) Match(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	return i.selector.Selects(ctx, pod)
+}
+
+// Inject implements injector.Injector
+func (i *Injector) Inject(ctx context.Context, pod *corev1.Pod) error {
+	for c := range pod.Spec.InitContainers {
+		i.setEnv(&pod.Spec.InitContainers[c])
+	}
+	for c := range pod.Spec.Containers {
+		i.setEnv(&pod.Spec.Containers[c])
+	}
+	return nil
+}
+
+// setEnv adds the variables the container does not set in env, in either case.
+func (i *Injector) setEnv(container *corev1.Container) {
+	set := map[string]struct{}{}
+	for _, env := range container.Env {
+		set[strings.ToUpper(env.Name)] = struct{}{}
+	}
+	for _, env := range i.env {
+		if _, ok := set[strings.ToUpper(env.Name)]; ok {
+			continue
+		}
+		container.Env = append(container.Env, env)
+	}
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestInjector(t *testing.T) {
+	spec.Run(t, "Injector", testInjector)
+}
+
+func testInjector(t *testing.T, when spec.G, it spec.S) {
+	var (
+		cfg config.Proxy
+		pod *corev1.Pod
+	)
+
+	it.Before(func() {
+		cfg = config.Proxy{
+			HTTPProxy:    "http://proxy.corp:3128",
+			HTTPSProxy:   "http://proxy.corp:3128",
+			NoProxy:      []string{"localhost", "127.0.0.1", ".corp"},
+			ServiceCIDRs: []string{"10.96.0.0/12"},
+		}
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-pod"},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Image: "image"}},
+				Containers:     []corev1.Container{{Name: "app", Image: "image"}},
+			},
+		}
+	})
+
+	newInjector := func(namespaces ...*corev1.Namespace) *Injector {
+		var objects []runtime.Object
+		for _, ns := range namespaces {
+			objects = append(objects, ns)
+		}
+		listers := wtesting.NewListers(objects)
+		i, err := New(cfg, listers.GetNamespaceLister())
+		require.NoError(t, err)
+		return i
+	}
+
+	when("#Inject", func() {
+		it("sets the variables in both cases on every container", func() {
+			require.NoError(t, newInjector().Inject(context.TODO(), pod))
+
+			noProxy := "localhost,127.0.0.1,.corp,10.96.0.0/12,.svc,.cluster.local"
+			expected := []corev1.EnvVar{
+				{Name: "HTTP_PROXY", Value: "http://proxy.corp:3128"},
+				{Name: "http_proxy", Value: "http://proxy.corp:3128"},
+				{Name: "HTTPS_PROXY", Value: "http://proxy.corp:3128"},
+				{Name: "https_proxy", Value: "http://proxy.corp:3128"},
+				{Name: "NO_PROXY", Value: noProxy},
+				{Name: "no_proxy", Value: noProxy},
+			}
+			assert.Equal(t, expected, pod.Spec.InitContainers[0].Env)
+			assert.Equal(t, expected, pod.Spec.Containers[0].Env)
+		})
+
+		it("uses the configured cluster domain and skips unset proxies", func() {
+			cfg.HTTPProxy = ""
+			cfg.NoProxy = []string{".svc"}
+			cfg.ServiceCIDRs = nil
+			cfg.ClusterDomain = "corp.local"
+			require.NoError(t, newInjector().Inject(context.TODO(), pod))
+
+			assert.Equal(t, []corev1.EnvVar{
+				{Name: "HTTPS_PROXY", Value: "http://proxy.corp:3128"},
+				{Name: "https_proxy", Value: "http://proxy.corp:3128"},
+				{Name: "NO_PROXY", Value: ".svc,.corp.local"},
+				{Name: "no_proxy", Value: ".svc,.corp.local"},
+			}, pod.Spec.Containers[0].Env)
+		})
+
+		it("sets the variables on the containers which may set them from envFrom", func() {
+			pod.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{{
+				ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app"}},
+			}}
+			require.NoError(t, newInjector().Inject(context.TODO(), pod))
+
+			assert.Len(t, pod.Spec.Containers[0].Env, 6)
+		})
+
+		it("keeps the variables a container already sets, in either case", func() {
+			pod.Spec.Containers[0].Env = []corev1.EnvVar{
+				{Name: "no_proxy", Value: "*"},
+				{Name: "HTTPS_PROXY", Value: "http://other:8080"},
+			}
+			require.NoError(t, newInjector().Inject(context.TODO(), pod))
+
+			assert.Equal(t, []corev1.EnvVar{
+				{Name: "no_proxy", Value: "*"},
+				{Name: "HTTPS_PROXY", Value: "http://other:8080"},
+				{Name: "HTTP_PROXY", Value: "http://proxy.corp:3128"},
+				{Name: "http_proxy", Value: "http://proxy.corp:3128"},
+			}, pod.Spec.Containers[0].Env)
+		})
+	})
+
+	when("#Match", func() {
+		match := func(i *Injector, namespace string) bool {
+			ctx := injector.WithRequest(context.TODO(), &admissionv1.AdmissionRequest{Namespace: namespace})
+			ok, err := i.Match(ctx, pod)
+			require.NoError(t, err)
+			return ok
+		}
+
+		it("matches every pod by default", func() {
+			assert.True(t, match(newInjector(), "some-namespace"))
+		})
+
+		it("matches the pods of the selected namespaces", func() {
+			cfg.NamespaceSelector = &config.LabelSelector{MatchLabels: map[string]string{"egress": "proxy"}}
+			i := newInjector(
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "proxied", Labels: map[string]string{"egress": "proxy"}}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "direct"}},
+			)
+
+			assert.True(t, match(i, "proxied"))
+			assert.False(t, match(i, "direct"))
+			assert.False(t, match(i, "unknown"))
+		})
+
+		it("lets the pod annotation override the namespace selection", func() {
+			cfg.NamespaceSelector = &config.LabelSelector{MatchLabels: map[string]string{"egress": "proxy"}}
+			i := newInjector(
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "proxied", Labels: map[string]string{"egress": "proxy"}}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "direct"}},
+			)
+
+			pod.Annotations = map[string]string{Annotation: "enabled"}
+			assert.True(t, match(i, "direct"))
+
+			pod.Annotations = map[string]string{Annotation: "disabled"}
+			assert.False(t, match(i, "proxied"))
+		})
+	})
+}
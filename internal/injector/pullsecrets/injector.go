@@ -0,0 +1,75 @@
+package pullsecrets
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+// Annotation opts a pod out of the imagePullSecrets injection.
+const Annotation = "pullsecrets.knurse.zezaeoh.io/inject"
+
+// Injector appends the replicated pull secrets to the imagePullSecrets of the pods.
+type Injector struct {
+	selector        *injector.Selector
+	secrets         []string
+	namespacelister corelisters.NamespaceLister
+}
+
+// New constructs the pullsecrets injector.
+func New(cfg config.PullSecrets, namespacelister corelisters.NamespaceLister) (*Injector, error) {
+	selector, err := injector.NewSelector(cfg.Selection, Annotation, namespacelister)
+	if err != nil {
+		return nil, err
+	}
+	return &Injector{selector: selector, secrets: cfg.Secrets, namespacelister: namespacelister}, nil
+}
+
+// NewFactory returns the factory of the pullsecrets injector.
+func NewFactory() injector.Factory {
+	return func(ctx context.Context, cfg *config.Config, _ config.InjectorConfig) (injector.Injector, error) {
+		return New(cfg.Webhook.PullSecrets, namespaceinformer.Get(ctx).Lister())
+	}
+}
+
+// Name implements injector.Injector
+func (i *Injector) Name() string {
+	return config.PullSecretsInjector
+}
+
+// Match implements injector.Injector
+func (i *Injector) Match(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	if selected, err := i.selector.Selects(ctx, pod); err != nil || !selected {
+		return false, err
+	}
+	// The secrets are only replicated into the selected namespaces, the
+	// annotation cannot opt a pod in outside of them.
+	ns, err := i.namespacelister.Get(injector.Namespace(ctx, pod))
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return i.selector.SelectsNamespace(ns), nil
+}
+
+// Inject implements injector.Injector
+func (i *Injector) Inject(_ context.Context, pod *corev1.Pod) error {
+	referenced := map[string]struct{}{}
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		referenced[ref.Name] = struct{}{}
+	}
+	for _, name := range i.secrets {
+		if _, ok := referenced[name]; ok {
+			continue
+		}
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+	return nil
+}
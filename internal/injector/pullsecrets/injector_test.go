@@ -0,0 +1,81 @@
+package pullsecrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestInjector(t *testing.T) {
+	spec.Run(t, "Injector", testInjector)
+}
+
+func testInjector(t *testing.T, when spec.G, it spec.S) {
+	when("#Match", func() {
+		var i *Injector
+
+		it.Before(func() {
+			listers := wtesting.NewListers([]runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "selected", Labels: map[string]string{"pull-secrets": "enabled"}}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+			})
+			var err error
+			i, err = New(config.PullSecrets{
+				Selection: config.Selection{NamespaceSelector: &config.LabelSelector{MatchLabels: map[string]string{"pull-secrets": "enabled"}}},
+				Secrets:   []string{"registry-corp"},
+			}, listers.GetNamespaceLister())
+			require.NoError(t, err)
+		})
+
+		it("matches the pods of the namespaces the secrets are replicated into", func() {
+			matched, err := i.Match(context.TODO(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "selected"}})
+			require.NoError(t, err)
+			assert.True(t, matched)
+		})
+
+		it("lets the pods opt out", func() {
+			matched, err := i.Match(context.TODO(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "selected",
+				Annotations: map[string]string{Annotation: config.AnnotationDisabled},
+			}})
+			require.NoError(t, err)
+			assert.False(t, matched)
+		})
+
+		it("does not let the pods of the other namespaces opt in", func() {
+			matched, err := i.Match(context.TODO(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "other",
+				Annotations: map[string]string{Annotation: config.AnnotationEnabled},
+			}})
+			require.NoError(t, err)
+			assert.False(t, matched)
+		})
+	})
+
+	when("#Inject", func() {
+		it("appends the pull secrets the pod does not reference yet", func() {
+			listers := wtesting.NewListers(nil)
+			i, err := New(config.PullSecrets{Secrets: []string{"registry-corp", "registry-partner"}}, listers.GetNamespaceLister())
+			require.NoError(t, err)
+
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "own"}, {Name: "registry-partner"}},
+			}}
+			require.NoError(t, i.Inject(context.TODO(), pod))
+
+			assert.Equal(t, []corev1.LocalObjectReference{
+				{Name: "own"},
+				{Name: "registry-partner"},
+				{Name: "registry-corp"},
+			}, pod.Spec.ImagePullSecrets)
+		})
+	})
+}
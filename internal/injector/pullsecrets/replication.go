@@ -0,0 +1,245 @@
+package pullsecrets
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret"
+	"knative.dev/pkg/logging"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	"knative.dev/pkg/system"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injection/kube/informers/core/v1/secret/replicated"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+const replicationQueueName = "PullSecretsReplication"
+
+// NewReplicationController constructs a controller replicating the pull
+// secrets into the namespaces selected by the pullsecrets injector, and
+// removing the copies from the other ones. It needs the informer of
+// replicated.WithInformer, and is only run while the injector is enabled.
+func NewReplicationController(ctx context.Context, cfg *config.Config) *controller.Impl {
+	namespaceInformer := namespaceinformer.Get(ctx)
+	secretInformer := secret.Get(ctx)
+	replicatedInformer := replicated.Get(ctx)
+	logger := logging.FromContext(ctx)
+
+	selector, err := injector.NewSelector(cfg.Webhook.PullSecrets.Selection, Annotation, namespaceInformer.Lister())
+	if err != nil {
+		logger.Fatalw("Failed to build the namespace selector of the pull secrets", zap.Error(err))
+	}
+	secrets := cfg.Webhook.PullSecrets.Secrets
+	protected := map[string]struct{}{system.Namespace(): {}}
+	for _, ns := range cfg.Webhook.ProtectedNamespaces {
+		protected[ns] = struct{}{}
+	}
+
+	r := &replicationReconciler{
+		secrets:             secrets,
+		selector:            selector,
+		protectedNamespaces: protected,
+		client:              kubeclient.Get(ctx),
+		namespacelister:     namespaceInformer.Lister(),
+		secretlister:        secretInformer.Lister(),
+		replicatedlister:    replicatedInformer.Lister(),
+	}
+	c := controller.NewImplFull(r, controller.ControllerOptions{WorkQueueName: replicationQueueName, Logger: logger.Named(replicationQueueName)})
+
+	enqueueAll := func() {
+		namespaces, err := r.namespacelister.List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list namespaces", zap.Error(err))
+			return
+		}
+		for _, ns := range namespaces {
+			c.EnqueueKey(types.NamespacedName{Name: ns.Name})
+		}
+	}
+	r.LeaderAwareFuncs = pkgreconciler.LeaderAwareFuncs{
+		// Have this reconciler enqueue every namespace whenever it becomes leader.
+		PromoteFunc: func(bkt pkgreconciler.Bucket, enq func(pkgreconciler.Bucket, types.NamespacedName)) error {
+			namespaces, err := r.namespacelister.List(labels.Everything())
+			if err != nil {
+				return err
+			}
+			for _, ns := range namespaces {
+				enq(bkt, types.NamespacedName{Name: ns.Name})
+			}
+			return nil
+		},
+	}
+
+	namespaceInformer.Informer().AddEventHandler(controller.HandleAll(c.Enqueue))
+	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			s, ok := obj.(metav1.Object)
+			return ok && s.GetNamespace() == system.Namespace() && injector.Contains(secrets, s.GetName())
+		},
+		Handler: controller.HandleAll(func(interface{}) { enqueueAll() }),
+	})
+	// Edited or deleted copies are repaired.
+	replicatedInformer.Informer().AddEventHandler(controller.HandleAll(func(obj interface{}) {
+		if s, ok := obj.(metav1.Object); ok {
+			c.EnqueueKey(types.NamespacedName{Name: s.GetNamespace()})
+		}
+	}))
+	return c
+}
+
+// replicationReconciler reconciles the copies of the pull secrets in a namespace.
+type replicationReconciler struct {
+	pkgreconciler.LeaderAwareFuncs
+
+	secrets             []string
+	selector            *injector.Selector
+	protectedNamespaces map[string]struct{}
+
+	client           kubernetes.Interface
+	namespacelister  corelisters.NamespaceLister
+	secretlister     corelisters.SecretLister
+	replicatedlister corelisters.SecretLister
+}
+
+var _ controller.Reconciler = (*replicationReconciler)(nil)
+var _ pkgreconciler.LeaderAware = (*replicationReconciler)(nil)
+
+// Reconcile implements controller.Reconciler
+func (r *replicationReconciler) Reconcile(ctx context.Context, key string) error {
+	logger := logging.FromContext(ctx)
+
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		logger.Errorw("Invalid resource key", zap.Error(err))
+		return nil
+	}
+	if !r.IsLeaderFor(types.NamespacedName{Name: name}) {
+		return controller.NewSkipKey(key)
+	}
+
+	ns, err := r.namespacelister.Get(name)
+	if apierrors.IsNotFound(err) {
+		// The copies are deleted along with the namespace.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	replicate := r.replicates(ns)
+
+	desired := map[string]struct{}{}
+	for _, secretName := range r.secrets {
+		desired[secretName] = struct{}{}
+		if !replicate {
+			continue
+		}
+		if err := r.reconcileSecret(ctx, ns.Name, secretName); err != nil {
+			return err
+		}
+	}
+
+	copies, err := r.replicatedlister.Secrets(ns.Name).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, copied := range copies {
+		if _, ok := desired[copied.Name]; ok && replicate {
+			continue
+		}
+		logger.Infof("Deleting pull secret %s/%s", copied.Namespace, copied.Name)
+		err := r.client.CoreV1().Secrets(copied.Namespace).Delete(ctx, copied.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pull secret %s/%s: %w", copied.Namespace, copied.Name, err)
+		}
+	}
+	return nil
+}
+
+// replicates returns whether the pull secrets are replicated into ns.
+func (r *replicationReconciler) replicates(ns *corev1.Namespace) bool {
+	if _, ok := r.protectedNamespaces[ns.Name]; ok {
+		return false
+	}
+	return ns.DeletionTimestamp == nil && r.selector.SelectsNamespace(ns)
+}
+
+func (r *replicationReconciler) reconcileSecret(ctx context.Context, namespace, name string) error {
+	logger := logging.FromContext(ctx)
+	secrets := r.client.CoreV1().Secrets(namespace)
+
+	source, err := r.secretlister.Secrets(system.Namespace()).Get(name)
+	if apierrors.IsNotFound(err) {
+		logger.Warnf("Pull secret %s/%s does not exist", system.Namespace(), name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if source.Type != corev1.SecretTypeDockerConfigJson && source.Type != corev1.SecretTypeDockercfg {
+		logger.Errorf("Pull secret %s/%s is of type %q, not a docker config", system.Namespace(), name, source.Type)
+		return nil
+	}
+
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{replicated.LabelKey: name},
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+
+	current, err := r.replicatedlister.Secrets(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		logger.Infof("Creating pull secret %s/%s", namespace, name)
+		_, err := secrets.Create(ctx, desired, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// Not labelled as a copy, the secret belongs to the namespace.
+			logger.Warnf("Secret %s/%s already exists, not replicating the pull secret", namespace, name)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to create pull secret %s/%s: %w", namespace, name, err)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if current.Type == desired.Type && equality.Semantic.DeepEqual(current.Data, desired.Data) &&
+		current.Labels[replicated.LabelKey] == name {
+		return nil
+	}
+	if current.Type != desired.Type {
+		// The type of a Secret is immutable.
+		logger.Infof("Recreating pull secret %s/%s", namespace, name)
+		if err := secrets.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pull secret %s/%s: %w", namespace, name, err)
+		}
+		if _, err := secrets.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create pull secret %s/%s: %w", namespace, name, err)
+		}
+		return nil
+	}
+
+	logger.Infof("Updating pull secret %s/%s", namespace, name)
+	updated := current.DeepCopy()
+	updated.Labels[replicated.LabelKey] = name
+	updated.Data = desired.Data
+	if _, err := secrets.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update pull secret %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
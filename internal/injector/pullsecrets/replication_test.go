@@ -0,0 +1,209 @@
+package pullsecrets
+
+import (
+	"testing"
+
+	"github.com/pivotal/kpack/pkg/reconciler/testhelpers"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injection/kube/informers/core/v1/secret/replicated"
+	"github.com/zezaeoh/knurse/internal/injector"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgotesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/controller"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	rtesting "knative.dev/pkg/reconciler/testing"
+	"knative.dev/pkg/system"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestReplicationReconciler(t *testing.T) {
+	spec.Run(t, "Replication Reconciler", testReplicationReconciler)
+}
+
+func testReplicationReconciler(t *testing.T, when spec.G, it spec.S) {
+	const secretName = "registry-corp"
+
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	pullSecret := func(namespace, name string, data string) *corev1.Secret {
+		s := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(data)},
+		}
+		if namespace != system.Namespace() {
+			s.Labels = map[string]string{replicated.LabelKey: name}
+		}
+		return s
+	}
+	source := pullSecret(system.Namespace(), secretName, `{"auths":{"registry.corp":{}}}`)
+	selected := map[string]string{"pull-secrets": "enabled"}
+
+	rt := testhelpers.ReconcilerTester(t,
+		func(t *testing.T, row *rtesting.TableRow) (controller.Reconciler, rtesting.ActionRecorderList, rtesting.EventList) {
+			listers := wtesting.NewListers(row.Objects)
+
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			for _, obj := range row.Objects {
+				if s, ok := obj.(*corev1.Secret); ok && s.Labels[replicated.LabelKey] != "" {
+					require.NoError(t, indexer.Add(s))
+				}
+			}
+
+			k8sfakeClient := k8sfake.NewSimpleClientset(listers.GetKubeObjects()...)
+			for _, reactor := range row.WithReactors {
+				k8sfakeClient.PrependReactor("*", "*", reactor)
+			}
+
+			selector, err := injector.NewSelector(config.Selection{
+				NamespaceSelector: &config.LabelSelector{MatchLabels: selected},
+			}, Annotation, listers.GetNamespaceLister())
+			require.NoError(t, err)
+
+			r := &replicationReconciler{
+				LeaderAwareFuncs: pkgreconciler.LeaderAwareFuncs{
+					PromoteFunc: func(pkgreconciler.Bucket, func(pkgreconciler.Bucket, types.NamespacedName)) error {
+						return nil
+					},
+				},
+				secrets:             []string{secretName},
+				selector:            selector,
+				protectedNamespaces: map[string]struct{}{system.Namespace(): {}, "kube-system": {}},
+				client:              k8sfakeClient,
+				namespacelister:     listers.GetNamespaceLister(),
+				secretlister:        listers.GetSecretLister(),
+				replicatedlister:    corelisters.NewSecretLister(indexer),
+			}
+			require.NoError(t, r.Promote(pkgreconciler.UniversalBucket(), func(pkgreconciler.Bucket, types.NamespacedName) {}))
+
+			return r, rtesting.ActionRecorderList{k8sfakeClient}, rtesting.EventList{Recorder: record.NewFakeRecorder(10)}
+		})
+
+	it("replicates the pull secrets into the selected namespaces", func() {
+		rt.Test(rtesting.TableRow{
+			Key: "team-a",
+			Objects: []runtime.Object{
+				namespace("team-a", selected),
+				source,
+			},
+			WantCreates: []runtime.Object{
+				pullSecret("team-a", secretName, `{"auths":{"registry.corp":{}}}`),
+			},
+			SkipNamespaceValidation: true,
+		})
+	})
+
+	it("updates outdated copies", func() {
+		rt.Test(rtesting.TableRow{
+			Key: "team-a",
+			Objects: []runtime.Object{
+				namespace("team-a", selected),
+				source,
+				pullSecret("team-a", secretName, `{"auths":{}}`),
+			},
+			WantUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: pullSecret("team-a", secretName, `{"auths":{"registry.corp":{}}}`),
+			}},
+			SkipNamespaceValidation: true,
+		})
+	})
+
+	it("leaves up to date copies alone", func() {
+		rt.Test(rtesting.TableRow{
+			Key: "team-a",
+			Objects: []runtime.Object{
+				namespace("team-a", selected),
+				source,
+				pullSecret("team-a", secretName, `{"auths":{"registry.corp":{}}}`),
+			},
+		})
+	})
+
+	it("does not overwrite secrets it did not replicate", func() {
+		own := pullSecret("team-a", secretName, `{"auths":{"registry.team-a":{}}}`)
+		own.Labels = nil
+
+		rt.Test(rtesting.TableRow{
+			Key: "team-a",
+			Objects: []runtime.Object{
+				namespace("team-a", selected),
+				source,
+				own,
+			},
+			WantCreates: []runtime.Object{
+				pullSecret("team-a", secretName, `{"auths":{"registry.corp":{}}}`),
+			},
+			SkipNamespaceValidation: true,
+		})
+	})
+
+	it("removes the copies from the namespaces no longer selected", func() {
+		rt.Test(rtesting.TableRow{
+			Key: "team-b",
+			Objects: []runtime.Object{
+				namespace("team-b", nil),
+				source,
+				pullSecret("team-b", secretName, `{"auths":{"registry.corp":{}}}`),
+			},
+			WantDeletes: []clientgotesting.DeleteActionImpl{{
+				ActionImpl: clientgotesting.ActionImpl{
+					Namespace: "team-b",
+					Verb:      "delete",
+					Resource:  corev1.SchemeGroupVersion.WithResource("secrets"),
+				},
+				Name: secretName,
+			}},
+			SkipNamespaceValidation: true,
+		})
+	})
+
+	it("removes the copies of the secrets no longer configured", func() {
+		rt.Test(rtesting.TableRow{
+			Key: "team-a",
+			Objects: []runtime.Object{
+				namespace("team-a", selected),
+				source,
+				pullSecret("team-a", secretName, `{"auths":{"registry.corp":{}}}`),
+				pullSecret("team-a", "registry-old", `{"auths":{"registry.old":{}}}`),
+			},
+			WantDeletes: []clientgotesting.DeleteActionImpl{{
+				ActionImpl: clientgotesting.ActionImpl{
+					Namespace: "team-a",
+					Verb:      "delete",
+					Resource:  corev1.SchemeGroupVersion.WithResource("secrets"),
+				},
+				Name: "registry-old",
+			}},
+			SkipNamespaceValidation: true,
+		})
+	})
+
+	it("never replicates into protected namespaces", func() {
+		rt.Test(rtesting.TableRow{
+			Key: "kube-system",
+			Objects: []runtime.Object{
+				namespace("kube-system", selected),
+				source,
+			},
+		})
+	})
+
+	it("does nothing while the source secret is missing", func() {
+		rt.Test(rtesting.TableRow{
+			Key: "team-a",
+			Objects: []runtime.Object{
+				namespace("team-a", selected),
+			},
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RawFields are fields of the containers which the Kubernetes API types knurse
+// is built with do not know, e.g. the restartPolicy of native sidecars. Injected
+// containers get the fields set by the injectors, existing ones keep the fields
+// of the admitted pod, wherever the injectors moved them.
+type RawFields struct {
+	initContainers map[string]map[string]interface{}
+}
+
+// SetInitContainerField sets field of the init container named container.
+func (f *RawFields) SetInitContainerField(container, field string, value interface{}) {
+	if f.initContainers == nil {
+		f.initContainers = map[string]map[string]interface{}{}
+	}
+	if f.initContainers[container] == nil {
+		f.initContainers[container] = map[string]interface{}{}
+	}
+	f.initContainers[container][field] = value
+}
+
+// Apply adds the raw fields to the patch turning the admitted pod, raw, into
+// mutated. When there are some, the container lists are replaced as a whole
+// since the patch of the typed pod may have moved their elements.
+func (f *RawFields) Apply(raw []byte, pod, mutated *corev1.Pod, patch []jsonpatch.JsonPatchOperation) ([]jsonpatch.JsonPatchOperation, error) {
+	var admitted struct {
+		Spec struct {
+			InitContainers []map[string]interface{} `json:"initContainers"`
+			Containers     []map[string]interface{} `json:"containers"`
+		} `json:"spec"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &admitted); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, list := range []struct {
+		field    string
+		admitted []map[string]interface{}
+		original []corev1.Container
+		mutated  []corev1.Container
+		set      map[string]map[string]interface{}
+	}{
+		{"initContainers", admitted.Spec.InitContainers, pod.Spec.InitContainers, mutated.Spec.InitContainers, f.initContainers},
+		{"containers", admitted.Spec.Containers, pod.Spec.Containers, mutated.Spec.Containers, nil},
+	} {
+		unknown, err := unknownFields(list.admitted, list.original)
+		if err != nil {
+			return nil, err
+		}
+		for name, fields := range list.set {
+			if unknown[name] == nil {
+				unknown[name] = map[string]interface{}{}
+			}
+			for field, value := range fields {
+				unknown[name][field] = value
+			}
+		}
+		if len(unknown) == 0 {
+			continue
+		}
+
+		containers, err := toMaps(list.mutated)
+		if err != nil {
+			return nil, err
+		}
+		for _, container := range containers {
+			for field, value := range unknown[container["name"].(string)] {
+				container[field] = value
+			}
+		}
+
+		path := "/spec/" + list.field
+		kept := patch[:0]
+		for _, op := range patch {
+			if op.Path != path && !strings.HasPrefix(op.Path, path+"/") {
+				kept = append(kept, op)
+			}
+		}
+		patch = append(kept, jsonpatch.NewOperation("add", path, containers))
+	}
+	return patch, nil
+}
+
+// unknownFields returns the fields of the admitted containers dropped by their
+// typed counterparts, by container name.
+func unknownFields(admitted []map[string]interface{}, typed []corev1.Container) (map[string]map[string]interface{}, error) {
+	known, err := toMaps(typed)
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := map[string]map[string]interface{}{}
+	for i, container := range admitted {
+		if i >= len(known) {
+			break
+		}
+		for field, value := range container {
+			if _, ok := known[i][field]; ok {
+				continue
+			}
+			if unknown[typed[i].Name] == nil {
+				unknown[typed[i].Name] = map[string]interface{}{}
+			}
+			unknown[typed[i].Name][field] = value
+		}
+	}
+	return unknown, nil
+}
+
+func toMaps(containers []corev1.Container) ([]map[string]interface{}, error) {
+	b, err := json.Marshal(containers)
+	if err != nil {
+		return nil, err
+	}
+	var maps []map[string]interface{}
+	return maps, json.Unmarshal(b, &maps)
+}
+
+type rawFieldsKey struct{}
+
+// WithRawFields attaches the raw fields of the pod being injected to ctx.
+func WithRawFields(ctx context.Context, f *RawFields) context.Context {
+	return context.WithValue(ctx, rawFieldsKey{}, f)
+}
+
+// GetRawFields returns the raw fields of the pod being injected, if any.
+func GetRawFields(ctx context.Context) *RawFields {
+	f, _ := ctx.Value(rawFieldsKey{}).(*RawFields)
+	return f
+}
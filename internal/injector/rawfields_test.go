@@ -0,0 +1,86 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis/duck"
+)
+
+func TestRawFields(t *testing.T) {
+	spec.Run(t, "RawFields", testRawFields)
+}
+
+func testRawFields(t *testing.T, when spec.G, it spec.S) {
+	const raw = `{
+  "metadata": {"name": "some-pod"},
+  "spec": {
+    "initContainers": [{"name": "mesh", "image": "mesh", "restartPolicy": "Always", "resources": {}}],
+    "containers": [{"name": "app", "image": "app", "resources": {}}]
+  }
+}`
+
+	var pod *corev1.Pod
+
+	it.Before(func() {
+		pod = &corev1.Pod{}
+		require.NoError(t, json.Unmarshal([]byte(raw), pod))
+	})
+
+	apply := func(f *RawFields, mutated *corev1.Pod) []jsonpatch.JsonPatchOperation {
+		patch, err := duck.CreatePatch(pod, mutated)
+		require.NoError(t, err)
+		patch, err = f.Apply([]byte(raw), pod, mutated, patch)
+		require.NoError(t, err)
+		return patch
+	}
+
+	when("#Apply", func() {
+		it("keeps the fields of the admitted containers moved by the injectors", func() {
+			mutated := pod.DeepCopy()
+			mutated.Spec.InitContainers = append([]corev1.Container{{Name: "setup", Image: "setup"}}, mutated.Spec.InitContainers...)
+
+			assert.Equal(t, []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/initContainers",
+				Value: []map[string]interface{}{
+					{"name": "setup", "image": "setup", "resources": map[string]interface{}{}},
+					{"name": "mesh", "image": "mesh", "restartPolicy": "Always", "resources": map[string]interface{}{}},
+				},
+			}}, apply(&RawFields{}, mutated))
+		})
+
+		it("sets the fields of the injected containers", func() {
+			mutated := pod.DeepCopy()
+			mutated.Spec.InitContainers = append(mutated.Spec.InitContainers, corev1.Container{Name: "shipper", Image: "shipper"})
+			f := &RawFields{}
+			f.SetInitContainerField("shipper", "restartPolicy", "Always")
+
+			patch := apply(f, mutated)
+			require.Len(t, patch, 1)
+			assert.Equal(t, []map[string]interface{}{
+				{"name": "mesh", "image": "mesh", "restartPolicy": "Always", "resources": map[string]interface{}{}},
+				{"name": "shipper", "image": "shipper", "restartPolicy": "Always", "resources": map[string]interface{}{}},
+			}, patch[0].Value)
+		})
+
+		it("leaves the patch alone without raw fields", func() {
+			pod.Spec.InitContainers = nil
+			mutated := pod.DeepCopy()
+			mutated.Spec.Containers[0].Image = "other"
+
+			patch, err := (&RawFields{}).Apply(nil, pod, mutated, []jsonpatch.JsonPatchOperation{
+				jsonpatch.NewOperation("replace", "/spec/containers/0/image", "other"),
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []jsonpatch.JsonPatchOperation{
+				jsonpatch.NewOperation("replace", "/spec/containers/0/image", "other"),
+			}, patch)
+		})
+	})
+}
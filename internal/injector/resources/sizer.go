@@ -0,0 +1,301 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	limitrangeinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/limitrange"
+	resourcequotainformer "knative.dev/pkg/client/injection/kube/informers/core/v1/resourcequota"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+// Annotation records on the pod how its injected containers were sized.
+const Annotation = "knurse.zezaeoh.io/resources"
+
+const (
+	// SourceConfig sizes the containers with webhook.resources.
+	SourceConfig = "config"
+	// SourceDefault sizes the containers with the defaults of knurse.
+	SourceDefault = "default"
+	// SourceNamespace sizes the containers with the defaults of knurse, fitted
+	// to the LimitRanges and ResourceQuotas of the namespace.
+	SourceNamespace = "namespace"
+)
+
+var (
+	// DefaultRequests are the requests of the injected containers, fitted to the namespace.
+	DefaultRequests = corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse("10m"),
+		corev1.ResourceMemory:           resource.MustParse("16Mi"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("16Mi"),
+	}
+	// DefaultLimits are the limits of the injected containers, fitted to the namespace.
+	DefaultLimits = corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse("100m"),
+		corev1.ResourceMemory:           resource.MustParse("64Mi"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("64Mi"),
+	}
+
+	// sized are the resources always sized, ephemeral storage is only sized
+	// when the namespace constrains it.
+	sized = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+)
+
+// Record is the value of Annotation.
+type Record struct {
+	Source         string                                 `json:"source"`
+	LimitRanges    []string                               `json:"limitRanges,omitempty"`
+	ResourceQuotas []string                               `json:"resourceQuotas,omitempty"`
+	Containers     map[string]corev1.ResourceRequirements `json:"containers"`
+}
+
+// Sizer sets the resources of the containers injected into the pods.
+type Sizer struct {
+	configured       *corev1.ResourceRequirements
+	limitrangelister corelisters.LimitRangeLister
+	quotalister      corelisters.ResourceQuotaLister
+}
+
+// New constructs a Sizer, sizing the containers from the namespace when cfg
+// is not configured.
+func New(cfg config.Resources, limitrangelister corelisters.LimitRangeLister, quotalister corelisters.ResourceQuotaLister) (*Sizer, error) {
+	s := &Sizer{limitrangelister: limitrangelister, quotalister: quotalister}
+	if cfg.Configured() {
+		requirements, err := cfg.Requirements()
+		if err != nil {
+			return nil, err
+		}
+		s.configured = &requirements
+	}
+	return s, nil
+}
+
+// NewSizer constructs a Sizer with the listers of ctx.
+func NewSizer(ctx context.Context, cfg *config.Config) (*Sizer, error) {
+	return New(cfg.Webhook.Resources, limitrangeinformer.Get(ctx).Lister(), resourcequotainformer.Get(ctx).Lister())
+}
+
+// Size sets the resources of the named containers of the pod setting none,
+// and records them in the annotation of the pod.
+func (s *Sizer) Size(namespace string, pod *corev1.Pod, names []string) error {
+	var containers []*corev1.Container
+	for _, name := range names {
+		if c := injector.Container(pod, name); c != nil && len(c.Resources.Requests) == 0 && len(c.Resources.Limits) == 0 {
+			containers = append(containers, c)
+		}
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+
+	record := Record{Source: SourceConfig}
+	requirements := s.configured
+	if requirements == nil {
+		var err error
+		if record, requirements, err = s.fit(namespace); err != nil {
+			return err
+		}
+	}
+
+	record.Containers = map[string]corev1.ResourceRequirements{}
+	for _, c := range containers {
+		c.Resources = *requirements.DeepCopy()
+		record.Containers[c.Name] = c.Resources
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[Annotation] = string(b)
+	return nil
+}
+
+// fit fits the default requirements to the LimitRanges and ResourceQuotas of
+// the namespace.
+func (s *Sizer) fit(namespace string) (Record, *corev1.ResourceRequirements, error) {
+	limitRanges, err := s.limitrangelister.LimitRanges(namespace).List(labels.Everything())
+	if err != nil {
+		return Record{}, nil, errors.Wrap(err, "failed to list the LimitRanges")
+	}
+	quotas, err := s.quotalister.ResourceQuotas(namespace).List(labels.Everything())
+	if err != nil {
+		return Record{}, nil, errors.Wrap(err, "failed to list the ResourceQuotas")
+	}
+	sort.Slice(limitRanges, func(i, j int) bool { return limitRanges[i].Name < limitRanges[j].Name })
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Name < quotas[j].Name })
+
+	record := Record{Source: SourceDefault}
+	names := append([]corev1.ResourceName{}, sized...)
+	for _, lr := range limitRanges {
+		record.LimitRanges = append(record.LimitRanges, lr.Name)
+		for _, item := range lr.Spec.Limits {
+			if item.Type == corev1.LimitTypeContainer {
+				names = constrained(names, item.Min, item.Max, item.MaxLimitRequestRatio, item.Default, item.DefaultRequest)
+			}
+		}
+	}
+	for _, quota := range quotas {
+		record.ResourceQuotas = append(record.ResourceQuotas, quota.Name)
+		names = constrained(names, quotaResources(quota.Spec.Hard))
+	}
+	if len(record.LimitRanges) > 0 || len(record.ResourceQuotas) > 0 {
+		record.Source = SourceNamespace
+	}
+
+	requirements := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	for _, name := range names {
+		request, limit := DefaultRequests[name].DeepCopy(), DefaultLimits[name].DeepCopy()
+		for _, lr := range limitRanges {
+			for _, item := range lr.Spec.Limits {
+				if item.Type == corev1.LimitTypeContainer {
+					request, limit = fitLimitRange(name, item, request, limit)
+				}
+			}
+		}
+		for _, quota := range quotas {
+			if request, limit, err = fitQuota(name, quota, request, limit); err != nil {
+				return Record{}, nil, err
+			}
+		}
+		// Capping to the quotas may have broken the LimitRanges again.
+		for _, lr := range limitRanges {
+			for _, item := range lr.Spec.Limits {
+				if item.Type == corev1.LimitTypeContainer {
+					if limit, err = fitRatio(name, lr.Name, item, request, limit); err != nil {
+						return Record{}, nil, err
+					}
+				}
+			}
+		}
+		requirements.Requests[name] = request
+		requirements.Limits[name] = limit
+	}
+	return record, &requirements, nil
+}
+
+// fitLimitRange fits request and limit between the min and max of the item,
+// raising the request to satisfy its maxLimitRequestRatio.
+func fitLimitRange(name corev1.ResourceName, item corev1.LimitRangeItem, request, limit resource.Quantity) (resource.Quantity, resource.Quantity) {
+	if min, ok := item.Min[name]; ok {
+		request, limit = maxQuantity(request, min), maxQuantity(limit, min)
+	}
+	if max, ok := item.Max[name]; ok {
+		limit = minQuantity(limit, max)
+	}
+	if ratio, ok := item.MaxLimitRequestRatio[name]; ok && ratio.MilliValue() > 0 {
+		// request >= limit / ratio, rounded up to the milli unit.
+		milli := (limit.MilliValue()*1000 + ratio.MilliValue() - 1) / ratio.MilliValue()
+		request = maxQuantity(request, *resource.NewMilliQuantity(milli, limit.Format))
+	}
+	return minQuantity(request, limit), limit
+}
+
+// fitQuota caps request and limit to what remains of the quota, failing when
+// nothing remains.
+func fitQuota(name corev1.ResourceName, quota *corev1.ResourceQuota, request, limit resource.Quantity) (resource.Quantity, resource.Quantity, error) {
+	for _, key := range []corev1.ResourceName{name, "requests." + name, "limits." + name} {
+		remaining, ok := remainingQuota(quota, key)
+		if !ok {
+			continue
+		}
+		if remaining.IsZero() {
+			return request, limit, errors.Errorf("ResourceQuota %q is exhausted: no %s left for the injected containers", quota.Name, key)
+		}
+		if key == "limits."+name {
+			limit = minQuantity(limit, remaining)
+		} else {
+			request = minQuantity(request, remaining)
+		}
+	}
+	return minQuantity(request, limit), limit, nil
+}
+
+// remainingQuota returns what remains of key in the quota, zero when its usage
+// exceeds it.
+func remainingQuota(quota *corev1.ResourceQuota, key corev1.ResourceName) (resource.Quantity, bool) {
+	hard, ok := quota.Spec.Hard[key]
+	if !ok {
+		return resource.Quantity{}, false
+	}
+	remaining := hard.DeepCopy()
+	if used, ok := quota.Status.Used[key]; ok {
+		remaining.Sub(used)
+	}
+	if remaining.Sign() < 0 {
+		remaining = *resource.NewQuantity(0, hard.Format)
+	}
+	return remaining, true
+}
+
+// fitRatio lowers limit to satisfy the maxLimitRequestRatio of the item once
+// request was capped to the quotas, failing when request fell below its min.
+func fitRatio(name corev1.ResourceName, limitRange string, item corev1.LimitRangeItem, request, limit resource.Quantity) (resource.Quantity, error) {
+	if min, ok := item.Min[name]; ok && request.Cmp(min) < 0 {
+		return limit, errors.Errorf("the ResourceQuotas leave %s of %s, below the min %s of LimitRange %q", request.String(), name, min.String(), limitRange)
+	}
+	if ratio, ok := item.MaxLimitRequestRatio[name]; ok && ratio.MilliValue() > 0 {
+		// limit <= request * ratio, rounded down to the milli unit.
+		milli := request.MilliValue() * ratio.MilliValue() / 1000
+		limit = minQuantity(limit, *resource.NewMilliQuantity(milli, limit.Format))
+	}
+	return limit, nil
+}
+
+// quotaResources returns the compute resources tracked by a quota, keyed by
+// their name without the requests. and limits. prefixes.
+func quotaResources(hard corev1.ResourceList) corev1.ResourceList {
+	resources := corev1.ResourceList{}
+	for key, q := range hard {
+		name := corev1.ResourceName(strings.TrimPrefix(strings.TrimPrefix(string(key), "requests."), "limits."))
+		resources[name] = q
+	}
+	return resources
+}
+
+// constrained adds to names the resources sized by knurse which are
+// constrained by one of lists.
+func constrained(names []corev1.ResourceName, lists ...corev1.ResourceList) []corev1.ResourceName {
+	seen := map[corev1.ResourceName]struct{}{}
+	for _, name := range names {
+		seen[name] = struct{}{}
+	}
+	for _, list := range lists {
+		for name := range list {
+			if _, ok := DefaultRequests[name]; !ok {
+				continue
+			}
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func minQuantity(a, b resource.Quantity) resource.Quantity {
+	if a.Cmp(b) > 0 {
+		return b
+	}
+	return a
+}
+
+func maxQuantity(a, b resource.Quantity) resource.Quantity {
+	if a.Cmp(b) < 0 {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,203 @@
+package resources
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestSizer(t *testing.T) {
+	spec.Run(t, "Sizer", testSizer)
+}
+
+func testSizer(t *testing.T, when spec.G, it spec.S) {
+	var pod *corev1.Pod
+
+	it.Before(func() {
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-pod", Namespace: "shop"},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "setup-ca-certs", Image: "setup"}},
+				Containers: []corev1.Container{
+					{Name: "app", Image: "app"},
+					{Name: "sized", Image: "sized", Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+					}},
+				},
+			},
+		}
+	})
+
+	newSizer := func(cfg config.Resources, objects ...runtime.Object) *Sizer {
+		listers := wtesting.NewListers(objects)
+		s, err := New(cfg,
+			corelisters.NewLimitRangeLister(listers.IndexerFor(&corev1.LimitRange{})),
+			corelisters.NewResourceQuotaLister(listers.IndexerFor(&corev1.ResourceQuota{})),
+		)
+		require.NoError(t, err)
+		return s
+	}
+
+	record := func() Record {
+		var r Record
+		require.NoError(t, json.Unmarshal([]byte(pod.Annotations[Annotation]), &r))
+		return r
+	}
+
+	quantities := func(list corev1.ResourceList) map[corev1.ResourceName]string {
+		m := map[corev1.ResourceName]string{}
+		for name, q := range list {
+			m[name] = q.String()
+		}
+		return m
+	}
+
+	it("uses the configured resources", func() {
+		s := newSizer(config.Resources{
+			Requests: map[corev1.ResourceName]string{corev1.ResourceCPU: "5m"},
+			Limits:   map[corev1.ResourceName]string{corev1.ResourceMemory: "32Mi"},
+		})
+		require.NoError(t, s.Size("shop", pod, []string{"setup-ca-certs"}))
+
+		resources := pod.Spec.InitContainers[0].Resources
+		assert.Equal(t, map[corev1.ResourceName]string{corev1.ResourceCPU: "5m"}, quantities(resources.Requests))
+		assert.Equal(t, map[corev1.ResourceName]string{corev1.ResourceMemory: "32Mi"}, quantities(resources.Limits))
+		assert.Equal(t, SourceConfig, record().Source)
+		assert.Contains(t, record().Containers, "setup-ca-certs")
+	})
+
+	it("only sizes the named containers which set no resources", func() {
+		s := newSizer(config.Resources{})
+		require.NoError(t, s.Size("shop", pod, []string{"sized", "unknown"}))
+
+		assert.Equal(t, map[corev1.ResourceName]string{corev1.ResourceMemory: "1Gi"}, quantities(pod.Spec.Containers[1].Resources.Limits))
+		assert.Empty(t, pod.Spec.Containers[0].Resources)
+		assert.NotContains(t, pod.Annotations, Annotation)
+	})
+
+	it("uses the defaults in unconstrained namespaces", func() {
+		s := newSizer(config.Resources{})
+		require.NoError(t, s.Size("shop", pod, []string{"setup-ca-certs"}))
+
+		resources := pod.Spec.InitContainers[0].Resources
+		assert.Equal(t, map[corev1.ResourceName]string{corev1.ResourceCPU: "10m", corev1.ResourceMemory: "16Mi"}, quantities(resources.Requests))
+		assert.Equal(t, map[corev1.ResourceName]string{corev1.ResourceCPU: "100m", corev1.ResourceMemory: "64Mi"}, quantities(resources.Limits))
+		assert.Equal(t, SourceDefault, record().Source)
+	})
+
+	it("fits the defaults to the LimitRanges of the namespace", func() {
+		s := newSizer(config.Resources{}, &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "shop"},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type: corev1.LimitTypeContainer,
+				Min: corev1.ResourceList{
+					corev1.ResourceCPU:              resource.MustParse("20m"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("1Mi"),
+				},
+				Max:                  corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("32Mi")},
+				MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+				Default:              corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+			}}},
+		}, &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-namespace", Namespace: "other"},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type: corev1.LimitTypeContainer,
+				Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1m")},
+			}}},
+		})
+		require.NoError(t, s.Size("shop", pod, []string{"setup-ca-certs"}))
+
+		resources := pod.Spec.InitContainers[0].Resources
+		assert.Equal(t, map[corev1.ResourceName]string{
+			corev1.ResourceCPU:              "50m",
+			corev1.ResourceMemory:           "16Mi",
+			corev1.ResourceEphemeralStorage: "16Mi",
+		}, quantities(resources.Requests))
+		assert.Equal(t, map[corev1.ResourceName]string{
+			corev1.ResourceCPU:              "100m",
+			corev1.ResourceMemory:           "32Mi",
+			corev1.ResourceEphemeralStorage: "64Mi",
+		}, quantities(resources.Limits))
+		assert.Equal(t, SourceNamespace, record().Source)
+		assert.Equal(t, []string{"limits"}, record().LimitRanges)
+	})
+
+	it("fits the defaults to what remains of the ResourceQuotas of the namespace", func() {
+		s := newSizer(config.Resources{}, &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "shop"},
+			Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+				"requests.memory": resource.MustParse("1Gi"),
+				"limits.memory":   resource.MustParse("1Gi"),
+				"limits.cpu":      resource.MustParse("1"),
+			}},
+			Status: corev1.ResourceQuotaStatus{Used: corev1.ResourceList{
+				"limits.memory": resource.MustParse("992Mi"),
+				"limits.cpu":    resource.MustParse("950m"),
+			}},
+		})
+		require.NoError(t, s.Size("shop", pod, []string{"setup-ca-certs"}))
+
+		resources := pod.Spec.InitContainers[0].Resources
+		assert.Equal(t, map[corev1.ResourceName]string{corev1.ResourceCPU: "10m", corev1.ResourceMemory: "16Mi"}, quantities(resources.Requests))
+		assert.Equal(t, map[corev1.ResourceName]string{corev1.ResourceCPU: "50m", corev1.ResourceMemory: "32Mi"}, quantities(resources.Limits))
+		assert.Equal(t, SourceNamespace, record().Source)
+		assert.Equal(t, []string{"quota"}, record().ResourceQuotas)
+	})
+	it("keeps the maxLimitRequestRatio of the LimitRanges once fitted to the ResourceQuotas", func() {
+		s := newSizer(config.Resources{}, &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "shop"},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type:                 corev1.LimitTypeContainer,
+				MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+			}}},
+		}, &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "shop"},
+			Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{"requests.cpu": resource.MustParse("1")}},
+			Status:     corev1.ResourceQuotaStatus{Used: corev1.ResourceList{"requests.cpu": resource.MustParse("995m")}},
+		})
+		require.NoError(t, s.Size("shop", pod, []string{"setup-ca-certs"}))
+
+		resources := pod.Spec.InitContainers[0].Resources
+		assert.Equal(t, "5m", quantities(resources.Requests)[corev1.ResourceCPU])
+		assert.Equal(t, "10m", quantities(resources.Limits)[corev1.ResourceCPU])
+	})
+
+	it("fails when a ResourceQuota is exhausted", func() {
+		s := newSizer(config.Resources{}, &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "shop"},
+			Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{"limits.memory": resource.MustParse("1Gi")}},
+			Status:     corev1.ResourceQuotaStatus{Used: corev1.ResourceList{"limits.memory": resource.MustParse("2Gi")}},
+		})
+		err := s.Size("shop", pod, []string{"setup-ca-certs"})
+
+		require.EqualError(t, err, `ResourceQuota "quota" is exhausted: no limits.memory left for the injected containers`)
+		assert.Empty(t, pod.Spec.InitContainers[0].Resources)
+	})
+
+	it("fails when the ResourceQuotas leave less than the min of a LimitRange", func() {
+		s := newSizer(config.Resources{}, &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "shop"},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type: corev1.LimitTypeContainer,
+				Min:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("20m")},
+			}}},
+		}, &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "shop"},
+			Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{"requests.cpu": resource.MustParse("1")}},
+			Status:     corev1.ResourceQuotaStatus{Used: corev1.ResourceList{"requests.cpu": resource.MustParse("990m")}},
+		})
+
+		require.EqualError(t, s.Size("shop", pod, []string{"setup-ca-certs"}),
+			`the ResourceQuotas leave 10m of cpu, below the min 20m of LimitRange "limits"`)
+	})
+}
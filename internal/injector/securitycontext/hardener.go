@@ -0,0 +1,158 @@
+package securitycontext
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+	"knative.dev/pkg/ptr"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+const (
+	// EnforceLabel is the namespace label setting the enforced Pod Security Standard.
+	EnforceLabel = "pod-security.kubernetes.io/enforce"
+	// LevelRestricted is the most restrictive Pod Security Standard.
+	LevelRestricted = "restricted"
+
+	// DefaultUser runs the hardened containers. The emptyDir volumes shared
+	// with the pod are world-writable, so any non-root user can write them.
+	DefaultUser = 65532
+
+	tmpVolumeName = "knurse-tmp"
+	tmpMountPath  = "/tmp"
+)
+
+// Default returns the securityContext of the hardened containers, satisfying
+// the restricted Pod Security Standard.
+func Default() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             ptr.Bool(true),
+		RunAsUser:                ptr.Int64(DefaultUser),
+		RunAsGroup:               ptr.Int64(DefaultUser),
+		AllowPrivilegeEscalation: ptr.Bool(false),
+		ReadOnlyRootFilesystem:   ptr.Bool(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// Hardener sets the securityContext of the containers injected into the pods.
+type Hardener struct {
+	policy          string
+	securityContext *corev1.SecurityContext
+	namespacelister corelisters.NamespaceLister
+}
+
+// New constructs a Hardener, overriding the Default securityContext with cfg.
+func New(cfg config.SecurityContext, namespacelister corelisters.NamespaceLister) (*Hardener, error) {
+	sc := Default()
+	if err := cfg.Decode(sc); err != nil {
+		return nil, err
+	}
+	policy := cfg.Policy
+	if policy == "" {
+		policy = config.SecurityContextNamespace
+	}
+	return &Hardener{policy: policy, securityContext: sc, namespacelister: namespacelister}, nil
+}
+
+// NewHardener constructs a Hardener with the listers of ctx.
+func NewHardener(ctx context.Context, cfg *config.Config) (*Hardener, error) {
+	return New(cfg.Webhook.SecurityContext, namespaceinformer.Get(ctx).Lister())
+}
+
+// Harden fills in the securityContext of the named containers of the pod
+// when the policy applies to the namespace, keeping the fields they set. A
+// read-only root filesystem gets a writable /tmp.
+func (h *Hardener) Harden(namespace string, pod *corev1.Pod, names []string) error {
+	if ok, err := h.applies(namespace); err != nil || !ok {
+		return err
+	}
+
+	var tmp bool
+	for _, name := range names {
+		c := injector.Container(pod, name)
+		if c == nil {
+			continue
+		}
+		if c.SecurityContext == nil {
+			c.SecurityContext = &corev1.SecurityContext{}
+		}
+		merge(c.SecurityContext, h.securityContext)
+
+		if readOnly := c.SecurityContext.ReadOnlyRootFilesystem; readOnly != nil && *readOnly && !injector.Mounts(c, tmpMountPath) {
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: tmpVolumeName, MountPath: tmpMountPath})
+			tmp = true
+		}
+	}
+	if tmp {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         tmpVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+	return nil
+}
+
+func (h *Hardener) applies(namespace string) (bool, error) {
+	switch h.policy {
+	case config.SecurityContextAlways:
+		return true, nil
+	case config.SecurityContextNever:
+		return false, nil
+	}
+	ns, err := h.namespacelister.Get(namespace)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return ns.Labels[EnforceLabel] == LevelRestricted, nil
+}
+
+// merge sets the fields of dst which are unset to the ones of src.
+func merge(dst, src *corev1.SecurityContext) {
+	if dst.RunAsNonRoot == nil && src.RunAsNonRoot != nil {
+		dst.RunAsNonRoot = ptr.Bool(*src.RunAsNonRoot)
+	}
+	if dst.RunAsUser == nil && src.RunAsUser != nil {
+		dst.RunAsUser = ptr.Int64(*src.RunAsUser)
+	}
+	if dst.RunAsGroup == nil && src.RunAsGroup != nil {
+		dst.RunAsGroup = ptr.Int64(*src.RunAsGroup)
+	}
+	if dst.AllowPrivilegeEscalation == nil && src.AllowPrivilegeEscalation != nil {
+		dst.AllowPrivilegeEscalation = ptr.Bool(*src.AllowPrivilegeEscalation)
+	}
+	if dst.ReadOnlyRootFilesystem == nil && src.ReadOnlyRootFilesystem != nil {
+		dst.ReadOnlyRootFilesystem = ptr.Bool(*src.ReadOnlyRootFilesystem)
+	}
+	if dst.Privileged == nil && src.Privileged != nil {
+		dst.Privileged = ptr.Bool(*src.Privileged)
+	}
+	if dst.Capabilities == nil && src.Capabilities != nil {
+		dst.Capabilities = src.Capabilities.DeepCopy()
+	}
+	if dst.SeccompProfile == nil && src.SeccompProfile != nil {
+		dst.SeccompProfile = src.SeccompProfile.DeepCopy()
+	}
+	if dst.SELinuxOptions == nil && src.SELinuxOptions != nil {
+		dst.SELinuxOptions = src.SELinuxOptions.DeepCopy()
+	}
+	if dst.WindowsOptions == nil && src.WindowsOptions != nil {
+		dst.WindowsOptions = src.WindowsOptions.DeepCopy()
+	}
+	if dst.ProcMount == nil && src.ProcMount != nil {
+		procMount := *src.ProcMount
+		dst.ProcMount = &procMount
+	}
+}
@@ -0,0 +1,113 @@
+package securitycontext
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/ptr"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestHardener(t *testing.T) {
+	spec.Run(t, "Hardener", testHardener)
+}
+
+func testHardener(t *testing.T, when spec.G, it spec.S) {
+	var pod *corev1.Pod
+
+	it.Before(func() {
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-pod"},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "setup-ca-certs", Image: "setup"}},
+				Containers: []corev1.Container{
+					{Name: "app", Image: "app"},
+					{Name: "sidecar", Image: "sidecar", SecurityContext: &corev1.SecurityContext{RunAsUser: ptr.Int64(1000)}},
+				},
+			},
+		}
+	})
+
+	newHardener := func(cfg string) *Hardener {
+		var sc config.SecurityContext
+		require.NoError(t, yaml.Unmarshal([]byte(cfg), &sc))
+
+		listers := wtesting.NewListers([]runtime.Object{
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "restricted", Labels: map[string]string{EnforceLabel: LevelRestricted}}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "baseline", Labels: map[string]string{EnforceLabel: "baseline"}}},
+		})
+		h, err := New(sc, listers.GetNamespaceLister())
+		require.NoError(t, err)
+		return h
+	}
+
+	it("hardens the named containers in namespaces enforcing the restricted standard", func() {
+		require.NoError(t, newHardener(`{}`).Harden("restricted", pod, []string{"setup-ca-certs"}))
+
+		assert.Equal(t, Default(), pod.Spec.InitContainers[0].SecurityContext)
+		assert.Nil(t, pod.Spec.Containers[0].SecurityContext)
+	})
+
+	it("mounts a writable /tmp into the containers with a read-only root filesystem", func() {
+		require.NoError(t, newHardener(`{}`).Harden("restricted", pod, []string{"setup-ca-certs"}))
+
+		assert.Equal(t, []corev1.VolumeMount{{Name: tmpVolumeName, MountPath: "/tmp"}}, pod.Spec.InitContainers[0].VolumeMounts)
+		assert.Equal(t, []corev1.Volume{{
+			Name:         tmpVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}}, pod.Spec.Volumes)
+	})
+
+	it("keeps the fields the containers set", func() {
+		require.NoError(t, newHardener(`{}`).Harden("restricted", pod, []string{"sidecar"}))
+
+		sc := pod.Spec.Containers[1].SecurityContext
+		assert.Equal(t, int64(1000), *sc.RunAsUser)
+		assert.True(t, *sc.RunAsNonRoot)
+		assert.Equal(t, []corev1.Capability{"ALL"}, sc.Capabilities.Drop)
+	})
+
+	it("leaves the containers alone in other namespaces", func() {
+		h := newHardener(`{}`)
+		require.NoError(t, h.Harden("baseline", pod, []string{"setup-ca-certs"}))
+		require.NoError(t, h.Harden("unknown", pod, []string{"setup-ca-certs"}))
+
+		assert.Nil(t, pod.Spec.InitContainers[0].SecurityContext)
+		assert.Empty(t, pod.Spec.Volumes)
+	})
+
+	it("hardens the containers in every namespace with the always policy", func() {
+		require.NoError(t, newHardener(`policy: always`).Harden("baseline", pod, []string{"setup-ca-certs"}))
+
+		assert.Equal(t, Default(), pod.Spec.InitContainers[0].SecurityContext)
+	})
+
+	it("never hardens the containers with the never policy", func() {
+		require.NoError(t, newHardener(`policy: never`).Harden("restricted", pod, []string{"setup-ca-certs"}))
+
+		assert.Nil(t, pod.Spec.InitContainers[0].SecurityContext)
+	})
+
+	it("overrides the defaults with the configured container securityContext", func() {
+		require.NoError(t, newHardener(`
+container:
+  runAsUser: null
+  runAsGroup: 2000
+  readOnlyRootFilesystem: false
+`).Harden("restricted", pod, []string{"setup-ca-certs"}))
+
+		sc := pod.Spec.InitContainers[0].SecurityContext
+		assert.Nil(t, sc.RunAsUser)
+		assert.Equal(t, int64(2000), *sc.RunAsGroup)
+		assert.False(t, *sc.ReadOnlyRootFilesystem)
+		assert.True(t, *sc.RunAsNonRoot)
+		assert.Empty(t, pod.Spec.InitContainers[0].VolumeMounts)
+	})
+}
@@ -0,0 +1,70 @@
+package injector
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/zezaeoh/knurse/internal/config"
+)
+
+// Selector selects the pods of a config.Selection.
+type Selector struct {
+	namespaceSelector labels.Selector
+	annotation        string
+	namespacelister   corelisters.NamespaceLister
+}
+
+// NewSelector constructs the selector of sel, defaulting its annotation to annotation.
+func NewSelector(sel config.Selection, annotation string, namespacelister corelisters.NamespaceLister) (*Selector, error) {
+	selector, err := metav1.LabelSelectorAsSelector(sel.NamespaceSelector.AsLabelSelector())
+	if err != nil {
+		return nil, err
+	}
+	if sel.Annotation != "" {
+		annotation = sel.Annotation
+	}
+	return &Selector{
+		namespaceSelector: selector,
+		annotation:        annotation,
+		namespacelister:   namespacelister,
+	}, nil
+}
+
+// Selects returns whether the pod being admitted is selected.
+func (s *Selector) Selects(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	switch pod.Annotations[s.annotation] {
+	case config.AnnotationEnabled:
+		return true, nil
+	case config.AnnotationDisabled:
+		return false, nil
+	}
+	if s.namespaceSelector.Empty() {
+		return true, nil
+	}
+
+	ns, err := s.namespacelister.Get(Namespace(ctx, pod))
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return s.SelectsNamespace(ns), nil
+}
+
+// SelectsNamespace returns whether the pods of ns are selected, unless annotated.
+func (s *Selector) SelectsNamespace(ns *corev1.Namespace) bool {
+	return s.namespaceSelector.Matches(labels.Set(ns.Labels))
+}
+
+// Namespace returns the namespace of the pod being admitted.
+func Namespace(ctx context.Context, pod *corev1.Pod) string {
+	if req := GetRequest(ctx); req != nil && req.Namespace != "" {
+		return req.Namespace
+	}
+	return pod.Namespace
+}
@@ -0,0 +1,218 @@
+package sidecars
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8syaml "sigs.k8s.io/yaml"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+// Annotation lists the names of the templates to inject into a pod, separated by commas.
+const Annotation = "sidecars.knurse.zezaeoh.io/inject"
+
+// Injector injects the containers of the templates a pod opts in to.
+type Injector struct {
+	templates map[string]*sidecarTemplate
+}
+
+type sidecarTemplate struct {
+	config.SidecarTemplate
+	container    *injector.Template
+	volumes      *injector.Template
+	volumeMounts *injector.Template
+}
+
+// rendered is a template executed over a pod.
+type rendered struct {
+	Container    corev1.Container
+	Volumes      []corev1.Volume
+	VolumeMounts []corev1.VolumeMount
+}
+
+// Data is the data the templates are executed over.
+type Data struct {
+	Pod PodData
+}
+
+// PodData is the metadata of the pod being injected.
+type PodData struct {
+	Name         string
+	GenerateName string
+	Namespace    string
+	Labels       map[string]string
+	Annotations  map[string]string
+}
+
+// New constructs the sidecars injector, parsing the templates.
+func New(templates []config.SidecarTemplate) (*Injector, error) {
+	i := &Injector{templates: map[string]*sidecarTemplate{}}
+	for _, tmpl := range templates {
+		st := &sidecarTemplate{SidecarTemplate: tmpl}
+		var err error
+		if st.container, err = injector.NewTemplate(tmpl.Name, &tmpl.Container); err != nil {
+			return nil, errors.Wrapf(err, "sidecar template %q: container", tmpl.Name)
+		}
+		if st.volumes, err = injector.NewTemplate(tmpl.Name, &tmpl.Volumes); err != nil {
+			return nil, errors.Wrapf(err, "sidecar template %q: volumes", tmpl.Name)
+		}
+		if st.volumeMounts, err = injector.NewTemplate(tmpl.Name, &tmpl.VolumeMounts); err != nil {
+			return nil, errors.Wrapf(err, "sidecar template %q: volumeMounts", tmpl.Name)
+		}
+		if _, err := st.render(Data{}); err != nil {
+			return nil, err
+		}
+		i.templates[tmpl.Name] = st
+	}
+	return i, nil
+}
+
+// NewFactory returns the factory of the sidecars injector.
+func NewFactory() injector.Factory {
+	return func(_ context.Context, cfg *config.Config, _ config.InjectorConfig) (injector.Injector, error) {
+		return New(cfg.Webhook.Sidecars.Templates)
+	}
+}
+
+// Name implements injector.Injector
+func (i *Injector) Name() string {
+	return config.SidecarsInjector
+}
+
+// Match implements injector.Injector
+func (i *Injector) Match(_ context.Context, pod *corev1.Pod) (bool, error) {
+	return len(requested(pod)) > 0, nil
+}
+
+// Inject implements injector.Injector
+func (i *Injector) Inject(ctx context.Context, pod *corev1.Pod) error {
+	data := Data{Pod: PodData{
+		Name:         pod.Name,
+		GenerateName: pod.GenerateName,
+		Namespace:    injector.Namespace(ctx, pod),
+		Labels:       pod.Labels,
+		Annotations:  pod.Annotations,
+	}}
+
+	for _, name := range requested(pod) {
+		tmpl, ok := i.templates[name]
+		if !ok {
+			return errors.Errorf("unknown sidecar template %q in the annotation %s", name, Annotation)
+		}
+		r, err := tmpl.render(data)
+		if err != nil {
+			return err
+		}
+		if injector.HasContainer(pod, r.Container.Name) {
+			continue
+		}
+
+		if tmpl.Kind == config.SidecarNative {
+			rawFields := injector.GetRawFields(ctx)
+			if rawFields == nil {
+				return errors.Errorf("sidecar template %q: native sidecars are not supported here", name)
+			}
+			rawFields.SetInitContainerField(r.Container.Name, "restartPolicy", string(corev1.RestartPolicyAlways))
+		}
+		addVolumes(pod, r.Volumes)
+		mountVolumes(pod, r.VolumeMounts, tmpl.Containers)
+
+		switch tmpl.Kind {
+		case config.SidecarInitContainer, config.SidecarNative:
+			pod.Spec.InitContainers = place(pod.Spec.InitContainers, r.Container, tmpl.Placement)
+		default:
+			pod.Spec.Containers = place(pod.Spec.Containers, r.Container, tmpl.Placement)
+		}
+	}
+	return nil
+}
+
+func (t *sidecarTemplate) render(data Data) (*rendered, error) {
+	r := &rendered{}
+	for _, part := range []struct {
+		field string
+		tmpl  *injector.Template
+		into  interface{}
+	}{
+		{"container", t.container, &r.Container},
+		{"volumes", t.volumes, &r.Volumes},
+		{"volumeMounts", t.volumeMounts, &r.VolumeMounts},
+	} {
+		b, err := part.tmpl.Execute(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sidecar template %q: %s", t.Name, part.field)
+		}
+		if err := k8syaml.UnmarshalStrict(b, part.into); err != nil {
+			return nil, errors.Wrapf(err, "sidecar template %q: %s", t.Name, part.field)
+		}
+	}
+	if r.Container.Name == "" {
+		return nil, errors.Errorf("sidecar template %q: container.name is required", t.Name)
+	}
+	return r, nil
+}
+
+// requested returns the names of the templates the pod opts in to.
+func requested(pod *corev1.Pod) []string {
+	var names []string
+	for _, name := range strings.Split(pod.Annotations[Annotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func addVolumes(pod *corev1.Pod, volumes []corev1.Volume) {
+	existing := map[string]struct{}{}
+	for _, v := range pod.Spec.Volumes {
+		existing[v.Name] = struct{}{}
+	}
+	for _, v := range volumes {
+		if _, ok := existing[v.Name]; ok {
+			continue
+		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v)
+	}
+}
+
+// mountVolumes attaches the mounts to the containers named in targets, to all
+// the containers when there are none.
+func mountVolumes(pod *corev1.Pod, mounts []corev1.VolumeMount, targets []string) {
+	if len(mounts) == 0 {
+		return
+	}
+	mount := func(c *corev1.Container) {
+		for _, m := range mounts {
+			if injector.Mounts(c, m.MountPath) {
+				continue
+			}
+			c.VolumeMounts = append(c.VolumeMounts, m)
+		}
+	}
+
+	if len(targets) == 0 {
+		for c := range pod.Spec.Containers {
+			mount(&pod.Spec.Containers[c])
+		}
+		return
+	}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for c := range containers {
+			if injector.Contains(targets, containers[c].Name) {
+				mount(&containers[c])
+			}
+		}
+	}
+}
+
+func place(containers []corev1.Container, container corev1.Container, placement string) []corev1.Container {
+	if placement == config.PlacementFirst {
+		return append([]corev1.Container{container}, containers...)
+	}
+	return append(containers, container)
+}
@@ -0,0 +1,180 @@
+package sidecars
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+	"gopkg.in/yaml.v3"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInjector(t *testing.T) {
+	spec.Run(t, "Injector", testInjector)
+}
+
+func testInjector(t *testing.T, when spec.G, it spec.S) {
+	var (
+		i         *Injector
+		pod       *corev1.Pod
+		rawFields *injector.RawFields
+		ctx       context.Context
+	)
+
+	it.Before(func() {
+		var cfg config.Sidecars
+		require.NoError(t, yaml.Unmarshal([]byte(`
+templates:
+  - name: log-shipper
+    kind: sidecar
+    placement: first
+    container:
+      name: log-shipper
+      image: fluent-bit
+      env:
+        - name: POD
+          value: "{{ .Pod.Namespace }}/{{ .Pod.Name }}"
+        - name: APP
+          value: '{{ index .Pod.Labels "app" }}'
+      volumeMounts:
+        - name: logs
+          mountPath: /logs
+    volumes:
+      - name: logs
+        emptyDir: {}
+    volumeMounts:
+      - name: logs
+        mountPath: /var/log/app
+    containers: [app]
+  - name: secret-fetcher
+    kind: initContainer
+    container:
+      name: secret-fetcher
+      image: fetcher
+  - name: exporter
+    container:
+      name: exporter
+      image: exporter
+`), &cfg))
+
+		var err error
+		i, err = New(cfg.Templates)
+		require.NoError(t, err)
+
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-pod", Labels: map[string]string{"app": "shop"}},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "migrate", Image: "migrate"}},
+				Containers:     []corev1.Container{{Name: "app", Image: "app"}, {Name: "proxy", Image: "proxy"}},
+			},
+		}
+		rawFields = &injector.RawFields{}
+		ctx = injector.WithRequest(context.TODO(), &admissionv1.AdmissionRequest{Namespace: "shop"})
+		ctx = injector.WithRawFields(ctx, rawFields)
+	})
+
+	when("#Match", func() {
+		it("matches the pods opting in to templates", func() {
+			ok, err := i.Match(ctx, pod)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			pod.Annotations = map[string]string{Annotation: " exporter "}
+			ok, err = i.Match(ctx, pod)
+			require.NoError(t, err)
+			assert.True(t, ok)
+		})
+	})
+
+	when("#Inject", func() {
+		it("injects native sidecars with their volumes and mounts", func() {
+			pod.Annotations = map[string]string{Annotation: "log-shipper"}
+			require.NoError(t, i.Inject(ctx, pod))
+
+			require.Len(t, pod.Spec.InitContainers, 2)
+			assert.Equal(t, corev1.Container{
+				Name:  "log-shipper",
+				Image: "fluent-bit",
+				Env: []corev1.EnvVar{
+					{Name: "POD", Value: "shop/some-pod"},
+					{Name: "APP", Value: "shop"},
+				},
+				VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/logs"}},
+			}, pod.Spec.InitContainers[0])
+			assert.Equal(t, []corev1.Volume{{
+				Name:         "logs",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}}, pod.Spec.Volumes)
+			assert.Equal(t, []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/app"}}, pod.Spec.Containers[0].VolumeMounts)
+			assert.Empty(t, pod.Spec.Containers[1].VolumeMounts)
+
+			patch, err := rawFields.Apply(nil, &corev1.Pod{}, pod, nil)
+			require.NoError(t, err)
+			require.Len(t, patch, 1)
+			assert.Equal(t, "Always", patch[0].Value.([]map[string]interface{})[0]["restartPolicy"])
+		})
+
+		it("places init containers and containers last by default", func() {
+			pod.Annotations = map[string]string{Annotation: "secret-fetcher,exporter"}
+			require.NoError(t, i.Inject(ctx, pod))
+
+			assert.Equal(t, []string{"migrate", "secret-fetcher"}, names(pod.Spec.InitContainers))
+			assert.Equal(t, []string{"app", "proxy", "exporter"}, names(pod.Spec.Containers))
+		})
+
+		it("does not inject a container twice", func() {
+			pod.Annotations = map[string]string{Annotation: "exporter"}
+			require.NoError(t, i.Inject(ctx, pod))
+			require.NoError(t, i.Inject(ctx, pod))
+
+			assert.Equal(t, []string{"app", "proxy", "exporter"}, names(pod.Spec.Containers))
+		})
+
+		it("does not let the pod change the structure of the templates", func() {
+			pod.Labels["app"] = "shop\n  securityContext:\n    privileged: true"
+			pod.Annotations = map[string]string{Annotation: "log-shipper"}
+			require.NoError(t, i.Inject(ctx, pod))
+
+			injected := pod.Spec.InitContainers[0]
+			assert.Equal(t, "log-shipper", injected.Name)
+			assert.Nil(t, injected.SecurityContext)
+			assert.Equal(t, corev1.EnvVar{Name: "APP", Value: pod.Labels["app"]}, injected.Env[1])
+		})
+
+		it("fails on unknown templates", func() {
+			pod.Annotations = map[string]string{Annotation: "exporter,unknown"}
+			require.EqualError(t, i.Inject(ctx, pod),
+				`unknown sidecar template "unknown" in the annotation sidecars.knurse.zezaeoh.io/inject`)
+		})
+	})
+
+	when("#New", func() {
+		it("rejects templates not rendering a container", func() {
+			var cfg config.Sidecars
+			require.NoError(t, yaml.Unmarshal([]byte(`
+templates:
+  - name: broken
+    container:
+      name: broken
+      imag: typo
+`), &cfg))
+
+			_, err := New(cfg.Templates)
+			require.Error(t, err)
+		})
+	})
+}
+
+func names(containers []corev1.Container) []string {
+	var names []string
+	for _, c := range containers {
+		names = append(names, c.Name)
+	}
+	return names
+}
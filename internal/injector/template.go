@@ -0,0 +1,109 @@
+package injector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template is a YAML document whose string values are Go templates. Only the
+// values are executed, one at a time, so the data, e.g. annotations of the
+// pods, cannot add keys or otherwise change the structure of the document.
+type Template struct {
+	value interface{}
+}
+
+// NewTemplate parses the string values of node as templates named name.
+func NewTemplate(name string, node *yaml.Node) (*Template, error) {
+	if node.Kind == 0 {
+		return &Template{}, nil
+	}
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	parsed, err := parseValue(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{value: parsed}, nil
+}
+
+// Execute executes the templates of t over data and returns the JSON of the
+// document, null when it is empty.
+func (t *Template) Execute(data interface{}) ([]byte, error) {
+	value, err := executeValue(t.value, data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func parseValue(name string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		return template.New(name).Option("missingkey=zero").Parse(v)
+	case map[string]interface{}:
+		parsed := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			p, err := parseValue(name, elem)
+			if err != nil {
+				return nil, err
+			}
+			parsed[key] = p
+		}
+		return parsed, nil
+	case []interface{}:
+		parsed := make([]interface{}, len(v))
+		for i, elem := range v {
+			p, err := parseValue(name, elem)
+			if err != nil {
+				return nil, err
+			}
+			parsed[i] = p
+		}
+		return parsed, nil
+	case map[interface{}]interface{}:
+		return nil, fmt.Errorf("keys must be strings")
+	}
+	return value, nil
+}
+
+func executeValue(value interface{}, data interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case *template.Template:
+		var b bytes.Buffer
+		if err := v.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		return b.String(), nil
+	case map[string]interface{}:
+		executed := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			e, err := executeValue(elem, data)
+			if err != nil {
+				return nil, err
+			}
+			executed[key] = e
+		}
+		return executed, nil
+	case []interface{}:
+		executed := make([]interface{}, len(v))
+		for i, elem := range v {
+			e, err := executeValue(elem, data)
+			if err != nil {
+				return nil, err
+			}
+			executed[i] = e
+		}
+		return executed, nil
+	}
+	return value, nil
+}
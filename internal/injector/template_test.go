@@ -0,0 +1,56 @@
+package injector
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTemplate(t *testing.T) {
+	spec.Run(t, "Template", testTemplate)
+}
+
+func testTemplate(t *testing.T, when spec.G, it spec.S) {
+	parse := func(doc string) *Template {
+		var node yaml.Node
+		require.NoError(t, yaml.Unmarshal([]byte(doc), &node))
+		tmpl, err := NewTemplate("test", node.Content[0])
+		require.NoError(t, err)
+		return tmpl
+	}
+
+	it("executes the string values", func() {
+		b, err := parse(`
+name: "{{ .Name }}"
+args: ["--pod", "{{ .Name }}", 2]
+nested:
+  enabled: true
+`).Execute(map[string]string{"Name": "some-pod"})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"name":"some-pod","args":["--pod","some-pod",2],"nested":{"enabled":true}}`, string(b))
+	})
+
+	it("does not let the data change the structure of the document", func() {
+		b, err := parse(`value: "{{ .Name }}"`).Execute(map[string]string{"Name": "x\nprivileged: true"})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"value":"x\nprivileged: true"}`, string(b))
+	})
+
+	it("renders null for an empty document", func() {
+		tmpl, err := NewTemplate("test", &yaml.Node{})
+		require.NoError(t, err)
+		b, err := tmpl.Execute(nil)
+		require.NoError(t, err)
+		assert.Equal(t, "null", string(b))
+	})
+
+	it("rejects invalid templates", func() {
+		var node yaml.Node
+		require.NoError(t, yaml.Unmarshal([]byte(`value: "{{ .Name"`), &node))
+		_, err := NewTemplate("test", node.Content[0])
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,183 @@
+package timezone
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/enum"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+const (
+	// Annotation opts a pod in or out of the timezone injection.
+	Annotation = "timezone.knurse.zezaeoh.io/inject"
+	// TZAnnotation of a namespace or a pod overrides the default time zone.
+	TZAnnotation = "timezone.knurse.zezaeoh.io/tz"
+
+	initContainerName  = "setup-zoneinfo"
+	zoneinfoVolumeName = "zoneinfo"
+	zoneinfoPath       = "/usr/share/zoneinfo"
+)
+
+// Injector sets TZ on every container not setting it already and, for images
+// without tzdata, mounts a zoneinfo database copied by an init container.
+type Injector struct {
+	selector      *injector.Selector
+	defaultTZ     string
+	zoneinfoImage string
+
+	namespacelister corelisters.NamespaceLister
+}
+
+// New constructs the timezone injector. The zoneinfo database is only mounted
+// when zoneinfoImage is set.
+func New(cfg config.Timezone, zoneinfoImage string, namespacelister corelisters.NamespaceLister) (*Injector, error) {
+	selector, err := injector.NewSelector(cfg.Selection, Annotation, namespacelister)
+	if err != nil {
+		return nil, err
+	}
+	return &Injector{
+		selector:        selector,
+		defaultTZ:       cfg.Default,
+		zoneinfoImage:   zoneinfoImage,
+		namespacelister: namespacelister,
+	}, nil
+}
+
+// NewFactory returns the factory of the timezone injector.
+func NewFactory() injector.Factory {
+	return func(ctx context.Context, cfg *config.Config, _ config.InjectorConfig) (injector.Injector, error) {
+		tz := cfg.Webhook.Timezone
+		var zoneinfoImage string
+		if tz.Zoneinfo.Enabled {
+			zoneinfoImage = tz.Zoneinfo.Image
+			if zoneinfoImage == "" {
+				zoneinfoImage = cfg.Webhook.CaCerts.SetupCaCertsImage
+			}
+		}
+		return New(tz, zoneinfoImage, namespaceinformer.Get(ctx).Lister())
+	}
+}
+
+// Name implements injector.Injector
+func (i *Injector) Name() string {
+	return config.TimezoneInjector
+}
+
+// Match implements injector.Injector
+func (i *Injector) Match(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	return i.selector.Selects(ctx, pod)
+}
+
+// Inject implements injector.Injector
+func (i *Injector) Inject(ctx context.Context, pod *corev1.Pod) error {
+	tz, err := i.timezone(ctx, pod)
+	if err != nil {
+		return err
+	}
+
+	env := corev1.EnvVar{Name: "TZ", Value: tz}
+	for c := range pod.Spec.InitContainers {
+		setEnv(&pod.Spec.InitContainers[c], env)
+	}
+	for c := range pod.Spec.Containers {
+		setEnv(&pod.Spec.Containers[c], env)
+	}
+
+	if i.zoneinfoImage != "" && tz != "UTC" && tz != "Etc/UTC" {
+		i.mountZoneinfo(pod)
+	}
+	return nil
+}
+
+// timezone returns the time zone of the pod, the one annotated on it or on its
+// namespace first. Unknown annotated time zones are ignored with a warning.
+func (i *Injector) timezone(ctx context.Context, pod *corev1.Pod) (string, error) {
+	if tz, ok := pod.Annotations[TZAnnotation]; ok {
+		if known(ctx, tz, "pod") {
+			return tz, nil
+		}
+	}
+
+	ns, err := i.namespacelister.Get(injector.Namespace(ctx, pod))
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	} else if err == nil {
+		if tz, ok := ns.Annotations[TZAnnotation]; ok && known(ctx, tz, "namespace") {
+			return tz, nil
+		}
+	}
+	return i.defaultTZ, nil
+}
+
+func known(ctx context.Context, tz, annotated string) bool {
+	if _, err := time.LoadLocation(tz); err != nil {
+		injector.Warn(ctx, "knurse: unknown time zone %q in the %s annotation %s, ignoring it", tz, annotated, TZAnnotation)
+		return false
+	}
+	return true
+}
+
+func setEnv(container *corev1.Container, env corev1.EnvVar) {
+	for _, e := range container.Env {
+		if e.Name == env.Name {
+			return
+		}
+	}
+	container.Env = append(container.Env, env)
+}
+
+// mountZoneinfo mounts the zoneinfo database on the containers not mounting
+// anything there already.
+func (i *Injector) mountZoneinfo(pod *corev1.Pod) {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == zoneinfoVolumeName {
+			return
+		}
+	}
+
+	mount := corev1.VolumeMount{
+		Name:      zoneinfoVolumeName,
+		MountPath: zoneinfoPath,
+		ReadOnly:  true,
+	}
+	mounted := false
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for c := range containers {
+			if injector.Mounts(&containers[c], zoneinfoPath) {
+				continue
+			}
+			containers[c].VolumeMounts = append(containers[c].VolumeMounts, mount)
+			mounted = true
+		}
+	}
+	if !mounted {
+		return
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: zoneinfoVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	container := corev1.Container{
+		Name:            initContainerName,
+		Image:           i.zoneinfoImage,
+		Command:         []string{"cp", "-R", zoneinfoPath + "/.", enum.SETUP_WORKSPACE},
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      zoneinfoVolumeName,
+				MountPath: enum.SETUP_WORKSPACE,
+			},
+		},
+	}
+	pod.Spec.InitContainers = append([]corev1.Container{container}, pod.Spec.InitContainers...)
+}
@@ -0,0 +1,136 @@
+package timezone
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestInjector(t *testing.T) {
+	spec.Run(t, "Injector", testInjector)
+}
+
+func testInjector(t *testing.T, when spec.G, it spec.S) {
+	const zoneinfoImage = "zezaeoh/setup-ca-certs"
+
+	var (
+		i   *Injector
+		pod *corev1.Pod
+		ctx = injector.WithRequest(context.TODO(), &admissionv1.AdmissionRequest{Namespace: "some-namespace"})
+	)
+
+	newInjector := func(image string, namespaceAnnotations map[string]string) *Injector {
+		listers := wtesting.NewListers([]runtime.Object{
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "some-namespace", Annotations: namespaceAnnotations}},
+		})
+		i, err := New(config.Timezone{Default: "Asia/Seoul"}, image, listers.GetNamespaceLister())
+		require.NoError(t, err)
+		return i
+	}
+
+	it.Before(func() {
+		i = newInjector("", nil)
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-pod"},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Image: "image"}},
+				Containers: []corev1.Container{
+					{Name: "app", Image: "image"},
+					{Name: "utc", Image: "image", Env: []corev1.EnvVar{{Name: "TZ", Value: "UTC"}}},
+				},
+			},
+		}
+	})
+
+	tzOf := func(container corev1.Container) string {
+		for _, env := range container.Env {
+			if env.Name == "TZ" {
+				return env.Value
+			}
+		}
+		return ""
+	}
+
+	when("#Inject", func() {
+		it("sets the default time zone on the containers not setting one", func() {
+			require.NoError(t, i.Inject(ctx, pod))
+
+			assert.Equal(t, "Asia/Seoul", tzOf(pod.Spec.InitContainers[0]))
+			assert.Equal(t, "Asia/Seoul", tzOf(pod.Spec.Containers[0]))
+			assert.Equal(t, []corev1.EnvVar{{Name: "TZ", Value: "UTC"}}, pod.Spec.Containers[1].Env)
+			assert.Empty(t, pod.Spec.Volumes)
+		})
+
+		it("prefers the annotation of the pod to the one of its namespace", func() {
+			i = newInjector("", map[string]string{TZAnnotation: "Europe/Paris"})
+
+			namespaced := pod.DeepCopy()
+			require.NoError(t, i.Inject(ctx, namespaced))
+			assert.Equal(t, "Europe/Paris", tzOf(namespaced.Spec.Containers[0]))
+
+			pod.Annotations = map[string]string{TZAnnotation: "America/New_York"}
+			require.NoError(t, i.Inject(ctx, pod))
+			assert.Equal(t, "America/New_York", tzOf(pod.Spec.Containers[0]))
+		})
+
+		it("ignores unknown time zones with a warning", func() {
+			i = newInjector("", map[string]string{TZAnnotation: "Europe/Atlantis"})
+			warnings := &injector.Warnings{}
+			pod.Annotations = map[string]string{TZAnnotation: "Mars/Olympus_Mons"}
+			require.NoError(t, i.Inject(injector.WithWarnings(ctx, warnings), pod))
+
+			assert.Equal(t, "Asia/Seoul", tzOf(pod.Spec.Containers[0]))
+			assert.Equal(t, []string{
+				`knurse: unknown time zone "Mars/Olympus_Mons" in the pod annotation timezone.knurse.zezaeoh.io/tz, ignoring it`,
+				`knurse: unknown time zone "Europe/Atlantis" in the namespace annotation timezone.knurse.zezaeoh.io/tz, ignoring it`,
+			}, warnings.List())
+		})
+
+		when("zoneinfo is enabled", func() {
+			it.Before(func() {
+				i = newInjector(zoneinfoImage, nil)
+			})
+
+			it("mounts the zoneinfo copied by an init container", func() {
+				pod.Spec.Containers[1].VolumeMounts = []corev1.VolumeMount{{Name: "own", MountPath: "/usr/share/zoneinfo"}}
+				require.NoError(t, i.Inject(ctx, pod))
+
+				assert.Equal(t, []corev1.Volume{{
+					Name:         "zoneinfo",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}}, pod.Spec.Volumes)
+				require.Len(t, pod.Spec.InitContainers, 2)
+				assert.Equal(t, corev1.Container{
+					Name:            "setup-zoneinfo",
+					Image:           zoneinfoImage,
+					Command:         []string{"cp", "-R", "/usr/share/zoneinfo/.", "/workspace"},
+					ImagePullPolicy: corev1.PullIfNotPresent,
+					VolumeMounts:    []corev1.VolumeMount{{Name: "zoneinfo", MountPath: "/workspace"}},
+				}, pod.Spec.InitContainers[0])
+
+				mount := corev1.VolumeMount{Name: "zoneinfo", MountPath: "/usr/share/zoneinfo", ReadOnly: true}
+				assert.Equal(t, []corev1.VolumeMount{mount}, pod.Spec.InitContainers[1].VolumeMounts)
+				assert.Equal(t, []corev1.VolumeMount{mount}, pod.Spec.Containers[0].VolumeMounts)
+				assert.Equal(t, []corev1.VolumeMount{{Name: "own", MountPath: "/usr/share/zoneinfo"}}, pod.Spec.Containers[1].VolumeMounts)
+			})
+
+			it("does not mount zoneinfo for pods in UTC", func() {
+				pod.Annotations = map[string]string{TZAnnotation: "UTC"}
+				require.NoError(t, i.Inject(ctx, pod))
+
+				assert.Empty(t, pod.Spec.Volumes)
+				assert.Len(t, pod.Spec.InitContainers, 1)
+			})
+		})
+	})
+}
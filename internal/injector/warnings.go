@@ -0,0 +1,36 @@
+package injector
+
+import (
+	"context"
+	"fmt"
+)
+
+// Warnings collects the warnings the injectors return to the client in the
+// admission response, e.g. about the parts of a pod they could not inject.
+type Warnings struct {
+	messages []string
+}
+
+// Add adds a warning.
+func (w *Warnings) Add(format string, args ...interface{}) {
+	w.messages = append(w.messages, fmt.Sprintf(format, args...))
+}
+
+// List returns the warnings in the order they were added.
+func (w *Warnings) List() []string {
+	return w.messages
+}
+
+type warningsKey struct{}
+
+// WithWarnings attaches the warnings of the pod being injected to ctx.
+func WithWarnings(ctx context.Context, w *Warnings) context.Context {
+	return context.WithValue(ctx, warningsKey{}, w)
+}
+
+// Warn adds a warning to the warnings of ctx, if any.
+func Warn(ctx context.Context, format string, args ...interface{}) {
+	if w, ok := ctx.Value(warningsKey{}).(*Warnings); ok {
+		w.Add(format, args...)
+	}
+}
@@ -0,0 +1,123 @@
+package admission
+
+import (
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/ptr"
+	"knative.dev/pkg/system"
+
+	"github.com/zezaeoh/knurse/internal/config"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "knurse"
+
+	defaultServicePort    = 443
+	defaultTimeoutSeconds = 10
+)
+
+// desiredWebhookConfiguration returns the MutatingWebhookConfiguration name knurse owns,
+// with every configured webhook entry of it, including the ones served at other paths.
+// Every field the API server would default is set, so that comparing it with the
+// configured object only reports actual drift.
+func (ac *reconciler) desiredWebhookConfiguration(name string, caCert []byte) *admissionregistrationv1.MutatingWebhookConfiguration {
+	mwc := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{managedByLabel: managedBy},
+		},
+	}
+	for _, entry := range ac.entries {
+		if entry.ConfigName == name {
+			mwc.Webhooks = append(mwc.Webhooks, ac.desiredWebhook(entry, caCert))
+		}
+	}
+	return mwc
+}
+
+func (ac *reconciler) desiredWebhook(entry config.WebhookEntry, caCert []byte) admissionregistrationv1.MutatingWebhook {
+	cfg := entry.MutatingWebhook
+
+	port := ac.servicePort
+	if port == 0 {
+		port = defaultServicePort
+	}
+	failurePolicy := admissionregistrationv1.Ignore
+	if cfg.FailurePolicy != "" {
+		failurePolicy = admissionregistrationv1.FailurePolicyType(cfg.FailurePolicy)
+	}
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	if cfg.SideEffects != "" {
+		sideEffects = admissionregistrationv1.SideEffectClass(cfg.SideEffects)
+	}
+	reinvocationPolicy := admissionregistrationv1.NeverReinvocationPolicy
+	if cfg.ReinvocationPolicy != "" {
+		reinvocationPolicy = admissionregistrationv1.ReinvocationPolicyType(cfg.ReinvocationPolicy)
+	}
+	timeoutSeconds := int32(defaultTimeoutSeconds)
+	if cfg.TimeoutSeconds != nil {
+		timeoutSeconds = *cfg.TimeoutSeconds
+	}
+	matchPolicy := admissionregistrationv1.Exact
+
+	return admissionregistrationv1.MutatingWebhook{
+		Name: entry.Name,
+		ClientConfig: admissionregistrationv1.WebhookClientConfig{
+			Service: &admissionregistrationv1.ServiceReference{
+				Name:      ac.serviceName,
+				Namespace: system.Namespace(),
+				Path:      ptr.String(entry.Path),
+				Port:      ptr.Int32(port),
+			},
+			CABundle: caCert,
+		},
+		Rules:                   convertRules(cfg.Rules),
+		FailurePolicy:           &failurePolicy,
+		MatchPolicy:             &matchPolicy,
+		NamespaceSelector:       ac.protectedNamespaceSelector(cfg.NamespaceSelector.AsLabelSelector()),
+		ObjectSelector:          cfg.ObjectSelector.AsLabelSelector(),
+		SideEffects:             &sideEffects,
+		TimeoutSeconds:          &timeoutSeconds,
+		AdmissionReviewVersions: []string{"v1"},
+		ReinvocationPolicy:      &reinvocationPolicy,
+	}
+}
+
+func convertRules(rules []config.Rule) []admissionregistrationv1.RuleWithOperations {
+	if len(rules) == 0 {
+		rules = []config.Rule{{
+			Operations:  []string{string(admissionregistrationv1.Create)},
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"pods"},
+		}}
+	}
+
+	converted := make([]admissionregistrationv1.RuleWithOperations, 0, len(rules))
+	for _, rule := range rules {
+		operations := make([]admissionregistrationv1.OperationType, 0, len(rule.Operations))
+		for _, op := range rule.Operations {
+			operations = append(operations, admissionregistrationv1.OperationType(op))
+		}
+		scope := admissionregistrationv1.AllScopes
+		if rule.Scope != "" {
+			scope = admissionregistrationv1.ScopeType(rule.Scope)
+		}
+		apiGroups := rule.APIGroups
+		if apiGroups == nil {
+			apiGroups = []string{""}
+		}
+
+		converted = append(converted, admissionregistrationv1.RuleWithOperations{
+			Operations: operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   apiGroups,
+				APIVersions: rule.APIVersions,
+				Resources:   rule.Resources,
+				Scope:       &scope,
+			},
+		})
+	}
+	return converted
+}
@@ -0,0 +1,181 @@
+package admission
+
+import (
+	"context"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/health"
+	"github.com/zezaeoh/knurse/internal/injector"
+	"github.com/zezaeoh/knurse/internal/injector/resources"
+	"github.com/zezaeoh/knurse/internal/injector/securitycontext"
+	"go.uber.org/zap"
+
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	mwhinformer "knative.dev/pkg/client/injection/kube/informers/admissionregistration/v1/mutatingwebhookconfiguration"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+	secretinformer "knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret"
+	pkgreconciler "knative.dev/pkg/reconciler"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"knative.dev/pkg/webhook"
+)
+
+const queueName = "Admission"
+
+// NewAdmissionController constructs a reconciler serving the webhook entries at path
+// with the injectors of registry enabled in the config. It reconciles the
+// MutatingWebhookConfigurations it owns, see ownedConfigNames.
+func NewAdmissionController(
+	ctx context.Context,
+	cfg *config.Config,
+	path string,
+	registry *injector.Registry,
+	tracker *health.Tracker,
+	wc func(context.Context) context.Context,
+) *controller.Impl {
+	client := kubeclient.Get(ctx)
+	mwhInformer := mwhinformer.Get(ctx)
+	secretInformer := secretinformer.Get(ctx)
+	options := webhook.GetOptions(ctx)
+	logger := logging.FromContext(ctx)
+
+	entries := cfg.WebhookEntries()
+	configNames := ownedConfigNames(entries, path)
+
+	injectors, err := registry.Build(ctx, cfg)
+	if err != nil {
+		logger.Fatalw("Failed to build injectors", zap.Error(err))
+	}
+	sizer, err := resources.NewSizer(ctx, cfg)
+	if err != nil {
+		logger.Fatalw("Failed to build the resources sizer", zap.Error(err))
+	}
+	hardener, err := securitycontext.NewHardener(ctx, cfg)
+	if err != nil {
+		logger.Fatalw("Failed to build the securityContext hardener", zap.Error(err))
+	}
+	injectorConditions := map[string][]config.MatchCondition{}
+	for _, ic := range cfg.EnabledInjectors() {
+		injectorConditions[ic.Name] = ic.MatchConditions
+	}
+
+	wh := &reconciler{
+		LeaderAwareFuncs: pkgreconciler.LeaderAwareFuncs{
+			// Have this reconciler enqueue our configurations whenever it becomes leader.
+			PromoteFunc: func(bkt pkgreconciler.Bucket, enq func(pkgreconciler.Bucket, types.NamespacedName)) error {
+				for name := range configNames {
+					enq(bkt, types.NamespacedName{Name: name})
+				}
+				return nil
+			},
+		},
+
+		path:        path,
+		entries:     entries,
+		configNames: configNames,
+
+		withContext: wc,
+
+		client:       client,
+		recorder:     eventRecorder(ctx, client),
+		health:       tracker,
+		mwhlister:    mwhInformer.Lister(),
+		secretlister: secretInformer.Lister(),
+
+		serviceName: options.ServiceName,
+		servicePort: cfg.Webhook.ServicePort,
+		secretName:  options.SecretName,
+
+		ownerClusterRole: cfg.Webhook.OwnerClusterRole,
+
+		protectedNamespaces: protectedNamespaces(cfg.Webhook.ProtectedNamespaces),
+		serviceAccountName:  cfg.Webhook.ServiceAccountName,
+		exemptions:          cfg.Webhook.Exemptions,
+		matchConditions:     cfg.Webhook.MatchConditions,
+		namespacelister:     namespaceinformer.Get(ctx).Lister(),
+
+		injectors:          injectors,
+		injectorConditions: injectorConditions,
+		sizer:              sizer,
+		hardener:           hardener,
+	}
+
+	name := queueName + path
+	c := controller.NewImplFull(wh, controller.ControllerOptions{WorkQueueName: name, Logger: logger.Named(name)})
+
+	// Reconcile when one of our MutatingWebhookConfigurations changes.
+	mwhInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			object, ok := obj.(interface{ GetName() string })
+			if !ok {
+				return false
+			}
+			_, ok = configNames[object.GetName()]
+			return ok
+		},
+		Handler: controller.HandleAll(c.Enqueue),
+	})
+
+	// Reconcile all of our configurations when the cert bundle changes.
+	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: controller.FilterWithNameAndNamespace(system.Namespace(), wh.secretName),
+		Handler: controller.HandleAll(func(interface{}) {
+			for name := range configNames {
+				c.EnqueueKey(types.NamespacedName{Name: name})
+			}
+		}),
+	})
+	return c
+}
+
+// ownedConfigNames returns the names of the MutatingWebhookConfigurations reconciled
+// by the controller serving path. A configuration holding entries served at several
+// paths is owned by the controller of its first entry only, so that a single
+// controller reconciles it with all its entries.
+func ownedConfigNames(entries []config.WebhookEntry, path string) map[string]struct{} {
+	configNames := map[string]struct{}{}
+	seen := map[string]struct{}{}
+	for _, entry := range entries {
+		if _, ok := seen[entry.ConfigName]; ok {
+			continue
+		}
+		seen[entry.ConfigName] = struct{}{}
+		if entry.Path == path {
+			configNames[entry.ConfigName] = struct{}{}
+		}
+	}
+	return configNames
+}
+
+// eventRecorder returns the recorder of ctx, or one emitting Events through client.
+func eventRecorder(ctx context.Context, client kubernetes.Interface) record.EventRecorder {
+	if recorder := controller.GetEventRecorder(ctx); recorder != nil {
+		return recorder
+	}
+
+	logger := logging.FromContext(ctx)
+	broadcaster := record.NewBroadcaster()
+	watches := []watch.Interface{
+		broadcaster.StartLogging(logger.Named("event-broadcaster").Infof),
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")}),
+	}
+	go func() {
+		<-ctx.Done()
+		for _, w := range watches {
+			w.Stop()
+		}
+	}()
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: managedBy})
+}
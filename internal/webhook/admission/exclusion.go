@@ -0,0 +1,113 @@
+package admission
+
+import (
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/pkg/system"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/injector"
+)
+
+// namespaceNameLabel is set on every namespace by the API server to its name.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// protectedNamespaces returns the knurse namespace followed by the configured
+// protected namespaces.
+func protectedNamespaces(configured []string) []string {
+	namespaces := []string{system.Namespace()}
+	for _, ns := range configured {
+		if ns != system.Namespace() {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// excluded returns why the pod of req must not be mutated, if it must not.
+// Mutating the pods of knurse or of the control plane could deadlock them
+// with a fail-closed webhook.
+func (ac *reconciler) excluded(req *admissionv1.AdmissionRequest, pod *corev1.Pod) (string, bool) {
+	namespace := req.Namespace
+	if namespace == "" {
+		namespace = pod.Namespace
+	}
+	// The pods are created by their controllers, not by knurse, so its own
+	// pods are told apart by the service account they run as.
+	if sa := ac.serviceAccountName; sa != "" && namespace == system.Namespace() && pod.Spec.ServiceAccountName == sa {
+		return "pod of the knurse service account", true
+	}
+	for _, ns := range ac.protectedNamespaces {
+		if namespace == ns {
+			return fmt.Sprintf("namespace %q is protected", namespace), true
+		}
+	}
+
+	for i, e := range ac.exemptions {
+		if exemptionMatches(e, req, namespace, pod) {
+			name := e.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			return fmt.Sprintf("exempted by %q", name), true
+		}
+	}
+	return "", false
+}
+
+// exemptionMatches returns whether every set field of e matches the pod of req.
+func exemptionMatches(e config.Exemption, req *admissionv1.AdmissionRequest, namespace string, pod *corev1.Pod) bool {
+	if len(e.Users) > 0 && !injector.Contains(e.Users, req.UserInfo.Username) {
+		return false
+	}
+	if len(e.Groups) > 0 && !containsAny(e.Groups, req.UserInfo.Groups) {
+		return false
+	}
+	if len(e.ServiceAccounts) > 0 {
+		sa := pod.Spec.ServiceAccountName
+		if sa == "" {
+			sa = "default"
+		}
+		if !injector.Contains(e.ServiceAccounts, sa) && !injector.Contains(e.ServiceAccounts, namespace+"/"+sa) {
+			return false
+		}
+	}
+	if len(e.OwnerKinds) > 0 {
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil {
+			return false
+		}
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			return false
+		}
+		if !injector.Contains(e.OwnerKinds, owner.Kind) && !injector.Contains(e.OwnerKinds, gv.Group+"/"+owner.Kind) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, c := range candidates {
+		if injector.Contains(values, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// protectedNamespaceSelector returns the namespace selector of a webhook entry,
+// which never selects the protected namespaces.
+func (ac *reconciler) protectedNamespaceSelector(selector *metav1.LabelSelector) *metav1.LabelSelector {
+	selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      namespaceNameLabel,
+		Operator: metav1.LabelSelectorOpNotIn,
+		Values:   ac.protectedNamespaces,
+	})
+	return selector
+}
@@ -0,0 +1,148 @@
+package admission
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/metrics"
+
+	"github.com/zezaeoh/knurse/internal/health"
+)
+
+// Reasons of the problems found on a MutatingWebhookConfiguration, used for
+// Events and metrics.
+const (
+	reasonConfigurationMissing = "ConfigurationMissing"
+	reasonWebhookMissing       = "WebhookMissing"
+	reasonServiceMissing       = "ServiceMissing"
+	reasonServiceMismatch      = "ServiceMismatch"
+	reasonCABundleMismatch     = "CABundleMismatch"
+	reasonRepaired             = "WebhookRepaired"
+	reasonRepairFailed         = "WebhookRepairFailed"
+)
+
+var (
+	webhookProblemsM = stats.Int64(
+		"webhook_configuration_problems",
+		"The number of problems found on a MutatingWebhookConfiguration by its last reconcile",
+		stats.UnitDimensionless)
+	webhookProblemCountM = stats.Int64(
+		"webhook_configuration_problem_count",
+		"The number of problems found on MutatingWebhookConfigurations",
+		stats.UnitDimensionless)
+
+	configurationKey = tag.MustNewKey("configuration")
+	reasonKey        = tag.MustNewKey("reason")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: webhookProblemsM.Description(),
+			Measure:     webhookProblemsM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{configurationKey},
+		},
+		&view.View{
+			Description: webhookProblemCountM.Description(),
+			Measure:     webhookProblemCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{configurationKey, reasonKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// checkWebhookConfiguration returns how the configured webhook entries differ
+// from the desired ones in ways that break admission.
+func checkWebhookConfiguration(configured, desired *admissionregistrationv1.MutatingWebhookConfiguration) []health.Problem {
+	var problems []health.Problem
+	for _, want := range desired.Webhooks {
+		got := findWebhook(configured.Webhooks, want.Name)
+		if got == nil {
+			problems = append(problems, health.Problem{
+				Webhook: want.Name,
+				Reason:  reasonWebhookMissing,
+				Message: fmt.Sprintf("webhook %q is missing", want.Name),
+			})
+			continue
+		}
+
+		gotSvc, wantSvc := got.ClientConfig.Service, want.ClientConfig.Service
+		switch {
+		case gotSvc == nil:
+			problems = append(problems, health.Problem{
+				Webhook: want.Name,
+				Reason:  reasonServiceMissing,
+				Message: fmt.Sprintf("webhook %q does not reference a service", want.Name),
+			})
+		case gotSvc.Name != wantSvc.Name || gotSvc.Namespace != wantSvc.Namespace ||
+			int32Value(gotSvc.Port, defaultServicePort) != int32Value(wantSvc.Port, defaultServicePort) ||
+			stringValue(gotSvc.Path) != stringValue(wantSvc.Path):
+			problems = append(problems, health.Problem{
+				Webhook: want.Name,
+				Reason:  reasonServiceMismatch,
+				Message: fmt.Sprintf("webhook %q calls %s, expected %s", want.Name, serviceString(gotSvc), serviceString(wantSvc)),
+			})
+		}
+
+		if !bytes.Equal(got.ClientConfig.CABundle, want.ClientConfig.CABundle) {
+			problems = append(problems, health.Problem{
+				Webhook: want.Name,
+				Reason:  reasonCABundleMismatch,
+				Message: fmt.Sprintf("webhook %q does not trust the serving certificate of knurse", want.Name),
+			})
+		}
+	}
+	return problems
+}
+
+// reportProblems records metrics and Warning Events for the problems found on mwc.
+func (ac *reconciler) reportProblems(ctx context.Context, mwc *admissionregistrationv1.MutatingWebhookConfiguration, problems []health.Problem) {
+	if ctx, err := tag.New(ctx, tag.Upsert(configurationKey, mwc.Name)); err == nil {
+		metrics.Record(ctx, webhookProblemsM.M(int64(len(problems))))
+		for _, p := range problems {
+			if ctx, err := tag.New(ctx, tag.Upsert(reasonKey, p.Reason)); err == nil {
+				metrics.Record(ctx, webhookProblemCountM.M(1))
+			}
+		}
+	}
+
+	for _, p := range problems {
+		ac.recorder.Event(mwc, corev1.EventTypeWarning, p.Reason, p.Message)
+	}
+}
+
+func findWebhook(webhooks []admissionregistrationv1.MutatingWebhook, name string) *admissionregistrationv1.MutatingWebhook {
+	for i := range webhooks {
+		if webhooks[i].Name == name {
+			return &webhooks[i]
+		}
+	}
+	return nil
+}
+
+func serviceString(svc *admissionregistrationv1.ServiceReference) string {
+	return fmt.Sprintf("%s/%s:%d%s", svc.Namespace, svc.Name, int32Value(svc.Port, defaultServicePort), stringValue(svc.Path))
+}
+
+func int32Value(v *int32, def int32) int32 {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+func stringValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
@@ -0,0 +1,82 @@
+package admission
+
+import (
+	"encoding/json"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/zezaeoh/knurse/internal/config"
+)
+
+// matchVariables returns a function building the variables of the match
+// conditions for req once, on first use.
+func (ac *reconciler) matchVariables(req *admissionv1.AdmissionRequest) func() (config.MatchVariables, error) {
+	var (
+		built bool
+		vars  config.MatchVariables
+		err   error
+	)
+	return func() (config.MatchVariables, error) {
+		if !built {
+			vars, err = ac.buildMatchVariables(req)
+			built = true
+		}
+		return vars, err
+	}
+}
+
+func (ac *reconciler) buildMatchVariables(req *admissionv1.AdmissionRequest) (config.MatchVariables, error) {
+	extra := make(map[string][]string, len(req.UserInfo.Extra))
+	for k, v := range req.UserInfo.Extra {
+		extra[k] = v
+	}
+	groups := req.UserInfo.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	vars := config.MatchVariables{
+		UserInfo: map[string]interface{}{
+			"username": req.UserInfo.Username,
+			"uid":      req.UserInfo.UID,
+			"groups":   groups,
+			"extra":    extra,
+		},
+	}
+	if err := json.Unmarshal(req.Object.Raw, &vars.Object); err != nil {
+		return vars, err
+	}
+	if req.Namespace != "" {
+		ns, err := ac.namespacelister.Get(req.Namespace)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return vars, err
+		} else if err == nil {
+			vars.NamespaceLabels = ns.Labels
+		}
+	}
+	return vars, nil
+}
+
+// matches returns whether the variables satisfy every condition, and the name
+// of the first condition they do not satisfy.
+func (ac *reconciler) matches(conditions []config.MatchCondition, vars func() (config.MatchVariables, error)) (bool, string, error) {
+	if len(conditions) == 0 {
+		return true, "", nil
+	}
+
+	v, err := vars()
+	if err != nil {
+		return false, "", err
+	}
+	for i := range conditions {
+		condition := &conditions[i]
+		ok, err := condition.Matches(v)
+		if err != nil {
+			return false, condition.Name, err
+		}
+		if !ok {
+			return false, condition.Name, nil
+		}
+	}
+	return true, "", nil
+}
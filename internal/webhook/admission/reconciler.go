@@ -0,0 +1,376 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/apis/duck"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/kmp"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"knative.dev/pkg/webhook"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	admissionlisters "k8s.io/client-go/listers/admissionregistration/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	certresources "knative.dev/pkg/webhook/certificates/resources"
+
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/health"
+	"github.com/zezaeoh/knurse/internal/injector"
+	"github.com/zezaeoh/knurse/internal/injector/resources"
+	"github.com/zezaeoh/knurse/internal/injector/securitycontext"
+)
+
+var (
+	universalDeserializer = serializer.NewCodecFactory(runtime.NewScheme()).UniversalDeserializer()
+	podResource           = metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+)
+
+// ephemeralContainersSubResource adds ephemeral containers to running pods.
+const ephemeralContainersSubResource = "ephemeralcontainers"
+
+type reconciler struct {
+	pkgreconciler.LeaderAwareFuncs
+
+	path        string
+	entries     []config.WebhookEntry
+	configNames map[string]struct{}
+
+	withContext func(context.Context) context.Context
+
+	client       kubernetes.Interface
+	recorder     record.EventRecorder
+	health       *health.Tracker
+	mwhlister    admissionlisters.MutatingWebhookConfigurationLister
+	secretlister corelisters.SecretLister
+
+	serviceName string
+	servicePort int32
+	secretName  string
+
+	// ownerClusterRole owns the MutatingWebhookConfigurations when set.
+	ownerClusterRole string
+
+	protectedNamespaces []string
+	serviceAccountName  string
+	exemptions          []config.Exemption
+	matchConditions     []config.MatchCondition
+	namespacelister     corelisters.NamespaceLister
+
+	// injectors run in order, each only when its match conditions are satisfied.
+	injectors          []injector.Injector
+	injectorConditions map[string][]config.MatchCondition
+	// sizer sets the resources of the containers added by the injectors.
+	sizer *resources.Sizer
+	// hardener sets the securityContext of the containers added by the injectors.
+	hardener *securitycontext.Hardener
+}
+
+// Reconcile implements controller.Reconciler
+func (ac *reconciler) Reconcile(ctx context.Context, key string) error {
+	logger := logging.FromContext(ctx)
+
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := ac.configNames[name]; !ok {
+		return nil
+	}
+	if !ac.IsLeaderFor(types.NamespacedName{Name: name}) {
+		return controller.NewSkipKey(key)
+	}
+
+	// Look up the webhook secret, and fetch the CA cert bundle.
+	secret, err := ac.secretlister.Secrets(system.Namespace()).Get(ac.secretName)
+	if err != nil {
+		logger.Errorw("Error fetching secret", zap.Error(err))
+		return err
+	}
+	caCert, ok := secret.Data[certresources.CACert]
+	if !ok {
+		return fmt.Errorf("secret %q is missing %q key", ac.secretName, certresources.CACert)
+	}
+
+	// Reconcile the webhook configuration.
+	return ac.reconcileMutatingWebhook(ctx, name, caCert)
+}
+
+// Path implements AdmissionController
+func (ac *reconciler) Path() string {
+	return ac.path
+}
+
+// Admit implements AdmissionController
+func (ac *reconciler) Admit(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if ac.withContext != nil {
+		ctx = ac.withContext(ctx)
+	}
+
+	logger := logging.FromContext(ctx)
+	if request.Resource != podResource {
+		logger.Infof("expected resource to be %v", podResource)
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	var ephemeral bool
+	switch {
+	case request.Operation == admissionv1.Create && request.SubResource == "":
+	case request.Operation == admissionv1.Update && request.SubResource == ephemeralContainersSubResource:
+		ephemeral = true
+	default:
+		logger.Info("Unhandled webhook operation, letting it through ", request.Operation)
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	raw := request.Object.Raw
+	pod := corev1.Pod{}
+	if _, _, err := universalDeserializer.Decode(raw, nil, &pod); err != nil {
+		reason := fmt.Sprintf("could not deserialize pod object: %v", err)
+		logger.Error(reason)
+		result := apierrors.NewBadRequest(reason).Status()
+		return &admissionv1.AdmissionResponse{
+			Result:  &result,
+			Allowed: true,
+		}
+	}
+
+	if reason, ok := ac.excluded(request, &pod); ok {
+		logger.Infof("Skipping pod: %s", reason)
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	vars := ac.matchVariables(request)
+	if ok, condition, err := ac.matches(ac.matchConditions, vars); err != nil {
+		logger.Errorw("Failed to evaluate match conditions, skipping pod", zap.String("condition", condition), zap.Error(err))
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	} else if !ok {
+		logger.Infof("Skipping pod: match condition %q is not satisfied", condition)
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	warnings := &injector.Warnings{}
+	patchBytes, err := ac.mutate(injector.WithWarnings(ctx, warnings), request, &pod, vars, ephemeral)
+	if err != nil {
+		return webhook.MakeErrorStatus("mutation failed: %v", err)
+	}
+	if patchBytes == nil {
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings.List()}
+	}
+
+	return &admissionv1.AdmissionResponse{
+		Patch:    patchBytes,
+		Allowed:  true,
+		Warnings: warnings.List(),
+		PatchType: func() *admissionv1.PatchType {
+			pt := admissionv1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
+
+// ownerReferences returns the owner of the MutatingWebhookConfigurations, the
+// configured ClusterRole. A missing ClusterRole is an error: it is being removed,
+// along with knurse, and a configuration created now would never be collected.
+func (ac *reconciler) ownerReferences(ctx context.Context) ([]metav1.OwnerReference, error) {
+	if ac.ownerClusterRole == "" {
+		return nil, nil
+	}
+	role, err := ac.client.RbacV1().ClusterRoles().Get(ctx, ac.ownerClusterRole, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving owner ClusterRole %q: %w", ac.ownerClusterRole, err)
+	}
+	return []metav1.OwnerReference{{
+		APIVersion: rbacv1.SchemeGroupVersion.String(),
+		Kind:       "ClusterRole",
+		Name:       role.Name,
+		UID:        role.UID,
+	}}, nil
+}
+
+func ownedBy(obj metav1.Object, owner metav1.OwnerReference) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.UID {
+			return true
+		}
+	}
+	return false
+}
+
+func (ac *reconciler) reconcileMutatingWebhook(ctx context.Context, name string, caCert []byte) error {
+	logger := logging.FromContext(ctx)
+	mwhclient := ac.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+	desired := ac.desiredWebhookConfiguration(name, caCert)
+	owners, err := ac.ownerReferences(ctx)
+	if err != nil {
+		return err
+	}
+	desired.OwnerReferences = owners
+
+	configuredWebhook, err := ac.mwhlister.Get(name)
+	if apierrors.IsNotFound(err) {
+		logger.Info("Creating webhook")
+		missing := health.Problem{Reason: reasonConfigurationMissing, Message: "webhook configuration is missing"}
+		if _, err := mwhclient.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+			ac.health.Detected(name, []health.Problem{missing, {
+				Reason:  reasonRepairFailed,
+				Message: fmt.Sprintf("failed to create webhook configuration: %v", err),
+			}}, false)
+			return fmt.Errorf("failed to create webhook: %w", err)
+		}
+		ac.health.Detected(name, []health.Problem{missing}, true)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error retrieving webhook: %w", err)
+	}
+
+	problems := checkWebhookConfiguration(configuredWebhook, desired)
+	for _, p := range problems {
+		logger.Warnw("Webhook is misconfigured", zap.String("reason", p.Reason), zap.String("message", p.Message))
+	}
+	ac.reportProblems(ctx, configuredWebhook, problems)
+
+	// knurse owns every webhook entry of the configuration, manual edits are reverted.
+	current := configuredWebhook.DeepCopy()
+	current.Webhooks = desired.Webhooks
+	if current.Labels == nil {
+		current.Labels = map[string]string{}
+	}
+	for k, v := range desired.Labels {
+		current.Labels[k] = v
+	}
+	for _, owner := range desired.OwnerReferences {
+		if !ownedBy(current, owner) {
+			current.OwnerReferences = append(current.OwnerReferences, owner)
+		}
+	}
+
+	if ok, err := kmp.SafeEqual(configuredWebhook, current); err != nil {
+		return fmt.Errorf("error diffing webhooks: %w", err)
+	} else if !ok {
+		logger.Info("Updating webhook")
+		if _, err := mwhclient.Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+			ac.recorder.Eventf(configuredWebhook, corev1.EventTypeWarning, reasonRepairFailed, "Failed to update webhook configuration: %v", err)
+			ac.health.Detected(name, append(problems, health.Problem{
+				Reason:  reasonRepairFailed,
+				Message: fmt.Sprintf("failed to update webhook configuration: %v", err),
+			}), false)
+			return fmt.Errorf("failed to update webhook: %w", err)
+		}
+		if len(problems) > 0 {
+			ac.recorder.Eventf(configuredWebhook, corev1.EventTypeNormal, reasonRepaired, "Repaired %d problem(s)", len(problems))
+			// Reported until a reconcile finds the configuration correct as is.
+			ac.health.Detected(name, problems, true)
+		}
+	} else {
+		logger.Info("Webhook is valid")
+		ac.health.Clear(name)
+	}
+	return nil
+}
+
+// mutate runs the injectors matching the pod in order, and returns their
+// changes as a single JSON patch, or nil when there are none. Only the
+// ephemeral injectors run on the ephemeral containers added to a running pod.
+func (ac *reconciler) mutate(ctx context.Context, req *admissionv1.AdmissionRequest, pod *corev1.Pod, vars func() (config.MatchVariables, error), ephemeral bool) ([]byte, error) {
+	logger := logging.FromContext(ctx)
+
+	if !ephemeral {
+		ctx = apis.WithinCreate(ctx)
+	}
+	ctx = apis.WithUserInfo(ctx, &req.UserInfo)
+	ctx = injector.WithRequest(ctx, req)
+	rawFields := &injector.RawFields{}
+	ctx = injector.WithRawFields(ctx, rawFields)
+
+	mutated := pod.DeepCopy()
+	for _, inj := range ac.injectors {
+		ephemeralInjector, ok := inj.(injector.EphemeralInjector)
+		if ephemeral && !ok {
+			continue
+		}
+		name := inj.Name()
+		logger := logger.With(zap.String("injector", name))
+
+		if ok, condition, err := ac.matches(ac.injectorConditions[name], vars); err != nil {
+			logger.Errorw("Failed to evaluate match conditions, skipping injector", zap.String("condition", condition), zap.Error(err))
+			continue
+		} else if !ok {
+			logger.Debugf("Skipping injector: match condition %q is not satisfied", condition)
+			continue
+		}
+
+		if ok, err := inj.Match(logging.WithLogger(ctx, logger), mutated); err != nil {
+			logger.Errorw("Failed to match the pod, skipping injector", zap.Error(err))
+			continue
+		} else if !ok {
+			continue
+		}
+		inject := inj.Inject
+		if ephemeral {
+			inject = ephemeralInjector.InjectEphemeral
+		}
+		if err := inject(logging.WithLogger(ctx, logger), mutated); err != nil {
+			return nil, errors.Wrapf(err, "injector %q", name)
+		}
+	}
+
+	if !ephemeral {
+		namespace, added := injector.Namespace(ctx, pod), addedContainers(pod, mutated)
+		if ac.sizer != nil {
+			if err := ac.sizer.Size(namespace, mutated, added); err != nil {
+				return nil, errors.Wrap(err, "failed to size the injected containers")
+			}
+		}
+		if ac.hardener != nil {
+			if err := ac.hardener.Harden(namespace, mutated, added); err != nil {
+				return nil, errors.Wrap(err, "failed to harden the injected containers")
+			}
+		}
+	}
+
+	patch, err := duck.CreatePatch(pod, mutated)
+	if err != nil {
+		return nil, err
+	}
+	if patch, err = rawFields.Apply(req.Object.Raw, pod, mutated, patch); err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(patch)
+}
+
+// addedContainers returns the names of the init and regular containers of
+// mutated which are not in pod.
+func addedContainers(pod, mutated *corev1.Pod) []string {
+	existing := map[string]struct{}{}
+	for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		existing[c.Name] = struct{}{}
+	}
+	var added []string
+	for _, c := range append(append([]corev1.Container{}, mutated.Spec.InitContainers...), mutated.Spec.Containers...) {
+		if _, ok := existing[c.Name]; !ok {
+			added = append(added, c.Name)
+		}
+	}
+	return added
+}
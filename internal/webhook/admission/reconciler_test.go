@@ -0,0 +1,1197 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/pivotal/kpack/pkg/reconciler/testhelpers"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zezaeoh/knurse/internal/bundle"
+	"github.com/zezaeoh/knurse/internal/config"
+	"github.com/zezaeoh/knurse/internal/health"
+	"github.com/zezaeoh/knurse/internal/injector"
+	"github.com/zezaeoh/knurse/internal/injector/cacerts"
+	"github.com/zezaeoh/knurse/internal/injector/resources"
+	"gomodules.xyz/jsonpatch/v2"
+	"gopkg.in/yaml.v3"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgotesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/ptr"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	rtesting "knative.dev/pkg/reconciler/testing"
+	"knative.dev/pkg/system"
+	certresources "knative.dev/pkg/webhook/certificates/resources"
+	wtesting "knative.dev/pkg/webhook/testing"
+)
+
+func TestReconciler(t *testing.T) {
+	spec.Run(t, "Reconciler", testReconciler)
+}
+
+func newStore(t *testing.T, data string) *bundle.Store {
+	store := bundle.NewStore(nil)
+	require.NoError(t, store.Set(string(bundle.SourceConfig), bundle.SourceConfig, data, ""))
+	return store
+}
+
+func testReconciler(t *testing.T, when spec.G, it spec.S) {
+	const (
+		name         = "some-webhook"
+		caSecretName = "some-secret"
+		caCertData   = `-----BEGIN CERTIFICATE-----
+MIIBbjCCAROgAwIBAgIQE6ttkwhxiyWXdGyCYeXx2TAKBggqhkjOPQQDAjAVMRMw
+EQYDVQQDEwp6ZXphZW9oLmlvMB4XDTIyMDMwMTExMDMxM1oXDTMyMDIyNzExMDMx
+M1owFTETMBEGA1UEAxMKemV6YWVvaC5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEH
+A0IABHX/JsHeUP4N3nqPrvxomMfEAZuVNZ4gqUxkYfZ4zBeInce/l0VJ3zs6T1UF
+CCrfz4Ikh808Hqn0WOkuuTrjAfqjRTBDMA4GA1UdDwEB/wQEAwIBBjASBgNVHRMB
+Af8ECDAGAQH/AgEBMB0GA1UdDgQWBBRZCI0gAEYflEredZJdcb4g8TaCSzAKBggq
+hkjOPQQDAgNJADBGAiEA6r77RFykldPNKKIzyazuDjQltBQpP5FXJH8u3jDx3tYC
+IQDo8vaYB9ySwxkM4aQvEZVvOUZf/uYVkwenThmIxDbw8w==
+-----END CERTIFICATE-----`
+		setupCaCertsImage = "zezaeoh/setup-ca-certs"
+		serviceName       = "some-service"
+	)
+	var (
+		key      = types.NamespacedName{Name: "some-webhook-config"}
+		multiKey = types.NamespacedName{Name: "multi-webhook-config"}
+		path     = "/some-path"
+		certData = []byte("some-cert")
+
+		failurePolicy      = admissionregistrationv1.Ignore
+		matchPolicy        = admissionregistrationv1.Exact
+		sideEffects        = admissionregistrationv1.SideEffectClassNone
+		reinvocationPolicy = admissionregistrationv1.NeverReinvocationPolicy
+		scope              = admissionregistrationv1.AllScopes
+		timeoutSeconds     = int32(10)
+		port               = int32(443)
+	)
+
+	newInjector := func(t *testing.T) injector.Injector {
+		return cacerts.New(config.CaCerts{Name: name, SetupCaCertsImage: setupCaCertsImage}, newStore(t, caCertData), nil)
+	}
+
+	expectedWebhook := func() admissionregistrationv1.MutatingWebhook {
+		return admissionregistrationv1.MutatingWebhook{
+			Name: name,
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
+					Name:      serviceName,
+					Namespace: system.Namespace(),
+					Path:      &path,
+					Port:      &port,
+				},
+				CABundle: certData,
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{
+				{
+					Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+					Rule: admissionregistrationv1.Rule{
+						APIGroups:   []string{""},
+						APIVersions: []string{"v1"},
+						Resources:   []string{"pods"},
+						Scope:       &scope,
+					},
+				},
+			},
+			FailurePolicy: &failurePolicy,
+			MatchPolicy:   &matchPolicy,
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      "kubernetes.io/metadata.name",
+					Operator: metav1.LabelSelectorOpNotIn,
+					Values:   []string{system.Namespace(), "kube-system"},
+				}},
+			},
+			ObjectSelector:          &metav1.LabelSelector{},
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeoutSeconds,
+			AdmissionReviewVersions: []string{"v1"},
+			ReinvocationPolicy:      &reinvocationPolicy,
+		}
+	}
+
+	when("#Reconcile", func() {
+		var tracker *health.Tracker
+		var ownerClusterRole string
+		it.Before(func() {
+			tracker = health.NewTracker()
+		})
+
+		rt := testhelpers.ReconcilerTester(t,
+			func(t *testing.T, row *rtesting.TableRow) (controller.Reconciler, rtesting.ActionRecorderList, rtesting.EventList) {
+				listers := wtesting.NewListers(row.Objects)
+				secretLister := listers.GetSecretLister()
+				mwhcLister := listers.GetMutatingWebhookConfigurationLister()
+
+				k8sfakeClient := k8sfake.NewSimpleClientset(listers.GetKubeObjects()...)
+				for _, reactor := range row.WithReactors {
+					k8sfakeClient.PrependReactor("*", "*", reactor)
+				}
+
+				eventRecorder := record.NewFakeRecorder(10)
+				actionRecorderList := rtesting.ActionRecorderList{k8sfakeClient}
+				eventList := rtesting.EventList{Recorder: eventRecorder}
+
+				r := &reconciler{
+					LeaderAwareFuncs: pkgreconciler.LeaderAwareFuncs{
+						// Have this reconciler enqueue our singleton whenever it becomes leader.
+						PromoteFunc: func(bkt pkgreconciler.Bucket, enq func(pkgreconciler.Bucket, types.NamespacedName)) error {
+							enq(bkt, key)
+							return nil
+						},
+					},
+
+					path: path,
+					entries: []config.WebhookEntry{
+						{ConfigName: key.Name, Name: name, Path: path},
+						{ConfigName: multiKey.Name, Name: name, Path: path},
+						{ConfigName: multiKey.Name, Name: "other-webhook", Path: "/other-path"},
+						{ConfigName: "other-webhook-config", Name: "other-webhook", Path: "/other-path"},
+					},
+					configNames: map[string]struct{}{key.Name: {}, multiKey.Name: {}},
+
+					client:       k8sfakeClient,
+					recorder:     eventRecorder,
+					health:       tracker,
+					mwhlister:    mwhcLister,
+					secretlister: secretLister,
+
+					serviceName: serviceName,
+					secretName:  caSecretName,
+
+					ownerClusterRole: ownerClusterRole,
+
+					protectedNamespaces: []string{system.Namespace(), "kube-system"},
+				}
+				r.Promote(pkgreconciler.UniversalBucket(), func(pkgreconciler.Bucket, types.NamespacedName) {})
+
+				return r, actionRecorderList, eventList
+			})
+
+		caSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      caSecretName,
+				Namespace: system.Namespace(),
+			},
+			Data: map[string][]byte{
+				certresources.CACert: certData,
+			},
+		}
+
+		it("Updates the webhook config with the ca cert secret", func() {
+			webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: key.Name,
+				},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					{
+						Name: name,
+						ClientConfig: admissionregistrationv1.WebhookClientConfig{
+							Service: &admissionregistrationv1.ServiceReference{},
+						},
+					},
+				},
+			}
+
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+					webhookConfig,
+				},
+				WantErr: false,
+				WantUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: &admissionregistrationv1.MutatingWebhookConfiguration{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:   key.Name,
+								Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+							},
+							Webhooks: []admissionregistrationv1.MutatingWebhook{
+								expectedWebhook(),
+							},
+						},
+					},
+				},
+				WantEvents: []string{
+					rtesting.Eventf(corev1.EventTypeWarning, "ServiceMismatch", `webhook "some-webhook" calls /:443, expected %s/some-service:443/some-path`, system.Namespace()),
+					rtesting.Eventf(corev1.EventTypeWarning, "CABundleMismatch", `webhook "some-webhook" does not trust the serving certificate of knurse`),
+					rtesting.Eventf(corev1.EventTypeNormal, "WebhookRepaired", "Repaired 2 problem(s)"),
+				},
+			})
+			report := tracker.Reports()[key.Name]
+			require.True(t, report.Repaired)
+			require.Len(t, report.Problems, 2)
+			require.False(t, report.DetectedAt.IsZero())
+			require.True(t, tracker.Ready())
+		})
+
+		it("Reports a missing webhook entry", func() {
+			webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: key.Name,
+				},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					{Name: "some-webhok"},
+				},
+			}
+
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+					webhookConfig,
+				},
+				WantErr: false,
+				WantUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: &admissionregistrationv1.MutatingWebhookConfiguration{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:   key.Name,
+								Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+							},
+							Webhooks: []admissionregistrationv1.MutatingWebhook{
+								expectedWebhook(),
+							},
+						},
+					},
+				},
+				WantEvents: []string{
+					rtesting.Eventf(corev1.EventTypeWarning, "WebhookMissing", `webhook "some-webhook" is missing`),
+					rtesting.Eventf(corev1.EventTypeNormal, "WebhookRepaired", "Repaired 1 problem(s)"),
+				},
+			})
+		})
+
+		it("Reports the problems it fails to repair as not ready", func() {
+			webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: key.Name,
+				},
+			}
+
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+					webhookConfig,
+				},
+				WithReactors: []clientgotesting.ReactionFunc{
+					rtesting.InduceFailure("update", "mutatingwebhookconfigurations"),
+				},
+				WantErr: true,
+				WantUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: &admissionregistrationv1.MutatingWebhookConfiguration{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:   key.Name,
+								Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+							},
+							Webhooks: []admissionregistrationv1.MutatingWebhook{
+								expectedWebhook(),
+							},
+						},
+					},
+				},
+				WantEvents: []string{
+					rtesting.Eventf(corev1.EventTypeWarning, "WebhookMissing", `webhook "some-webhook" is missing`),
+					rtesting.Eventf(corev1.EventTypeWarning, "WebhookRepairFailed", "Failed to update webhook configuration: inducing failure for update mutatingwebhookconfigurations"),
+				},
+			})
+
+			report := tracker.Reports()[key.Name]
+			require.False(t, report.Repaired)
+			require.Len(t, report.Problems, 2)
+			require.Equal(t, "WebhookMissing", report.Problems[0].Reason)
+			require.Equal(t, "WebhookRepairFailed", report.Problems[1].Reason)
+			require.False(t, tracker.Ready())
+		})
+
+		it("Creates the webhook config when it is missing", func() {
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+				},
+				// MutatingWebhookConfigurations are cluster scoped.
+				SkipNamespaceValidation: true,
+				WantErr:                 false,
+				WantCreates: []runtime.Object{
+					&admissionregistrationv1.MutatingWebhookConfiguration{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   key.Name,
+							Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+						},
+						Webhooks: []admissionregistrationv1.MutatingWebhook{
+							expectedWebhook(),
+						},
+					},
+				},
+			})
+
+			report := tracker.Reports()[key.Name]
+			require.True(t, report.Repaired)
+			require.Equal(t, "ConfigurationMissing", report.Problems[0].Reason)
+		})
+
+		it("Is not ready when it fails to create the missing webhook config", func() {
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+				},
+				WithReactors: []clientgotesting.ReactionFunc{
+					rtesting.InduceFailure("create", "mutatingwebhookconfigurations"),
+				},
+				SkipNamespaceValidation: true,
+				WantErr:                 true,
+				WantCreates: []runtime.Object{
+					&admissionregistrationv1.MutatingWebhookConfiguration{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   key.Name,
+							Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+						},
+						Webhooks: []admissionregistrationv1.MutatingWebhook{
+							expectedWebhook(),
+						},
+					},
+				},
+			})
+
+			require.False(t, tracker.Ready())
+		})
+
+		it("Reverts manual edits and removes unknown webhooks", func() {
+			edited := expectedWebhook()
+			failurePolicy := admissionregistrationv1.Fail
+			edited.FailurePolicy = &failurePolicy
+
+			webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: key.Name,
+				},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					edited,
+					{Name: "unknown-webhook"},
+				},
+			}
+
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+					webhookConfig,
+				},
+				WantErr: false,
+				WantUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: &admissionregistrationv1.MutatingWebhookConfiguration{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:   key.Name,
+								Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+							},
+							Webhooks: []admissionregistrationv1.MutatingWebhook{
+								expectedWebhook(),
+							},
+						},
+					},
+				},
+			})
+		})
+
+		it("Creates every webhook entry of the config, including the ones served at other paths", func() {
+			otherWebhook := expectedWebhook()
+			otherWebhook.Name = "other-webhook"
+			otherWebhook.ClientConfig.Service.Path = ptr.String("/other-path")
+
+			rt.Test(rtesting.TableRow{
+				Key: multiKey.Name,
+				Objects: []runtime.Object{
+					caSecret,
+				},
+				SkipNamespaceValidation: true,
+				WantErr:                 false,
+				WantCreates: []runtime.Object{
+					&admissionregistrationv1.MutatingWebhookConfiguration{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   multiKey.Name,
+							Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+						},
+						Webhooks: []admissionregistrationv1.MutatingWebhook{
+							expectedWebhook(),
+							otherWebhook,
+						},
+					},
+				},
+			})
+		})
+
+		it("Ignores configs without entries served at its path", func() {
+			rt.Test(rtesting.TableRow{
+				Key: "other-webhook-config",
+				Objects: []runtime.Object{
+					caSecret,
+				},
+				WantErr: false,
+			})
+		})
+
+		it("Labels a webhook config it did not create", func() {
+			webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   key.Name,
+					Labels: map[string]string{"team": "platform"},
+				},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					expectedWebhook(),
+				},
+			}
+
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+					webhookConfig,
+				},
+				WantErr: false,
+				WantUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: &admissionregistrationv1.MutatingWebhookConfiguration{
+							ObjectMeta: metav1.ObjectMeta{
+								Name: key.Name,
+								Labels: map[string]string{
+									"team":                         "platform",
+									"app.kubernetes.io/managed-by": "knurse",
+								},
+							},
+							Webhooks: []admissionregistrationv1.MutatingWebhook{
+								expectedWebhook(),
+							},
+						},
+					},
+				},
+			})
+		})
+
+		when("an owner ClusterRole is configured", func() {
+			clusterRole := &rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "knurse-cluster-role",
+					UID:  "cluster-role-uid",
+				},
+			}
+			owner := metav1.OwnerReference{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "ClusterRole",
+				Name:       "knurse-cluster-role",
+				UID:        "cluster-role-uid",
+			}
+
+			it.Before(func() {
+				ownerClusterRole = "knurse-cluster-role"
+			})
+
+			it.After(func() {
+				ownerClusterRole = ""
+			})
+
+			it("Creates the webhook config owned by it", func() {
+				rt.Test(rtesting.TableRow{
+					Key: key.Name,
+					Objects: []runtime.Object{
+						caSecret,
+						clusterRole,
+					},
+					SkipNamespaceValidation: true,
+					WantErr:                 false,
+					WantCreates: []runtime.Object{
+						&admissionregistrationv1.MutatingWebhookConfiguration{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:            key.Name,
+								Labels:          map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+								OwnerReferences: []metav1.OwnerReference{owner},
+							},
+							Webhooks: []admissionregistrationv1.MutatingWebhook{
+								expectedWebhook(),
+							},
+						},
+					},
+				})
+			})
+
+			it("Adds it to the owners of an existing webhook config", func() {
+				other := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "other-uid"}
+				webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            key.Name,
+						Labels:          map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+						OwnerReferences: []metav1.OwnerReference{other},
+					},
+					Webhooks: []admissionregistrationv1.MutatingWebhook{
+						expectedWebhook(),
+					},
+				}
+
+				rt.Test(rtesting.TableRow{
+					Key: key.Name,
+					Objects: []runtime.Object{
+						caSecret,
+						clusterRole,
+						webhookConfig,
+					},
+					WantErr: false,
+					WantUpdates: []clientgotesting.UpdateActionImpl{
+						{
+							Object: &admissionregistrationv1.MutatingWebhookConfiguration{
+								ObjectMeta: metav1.ObjectMeta{
+									Name:            key.Name,
+									Labels:          map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+									OwnerReferences: []metav1.OwnerReference{other, owner},
+								},
+								Webhooks: []admissionregistrationv1.MutatingWebhook{
+									expectedWebhook(),
+								},
+							},
+						},
+					},
+				})
+			})
+
+			it("Does not create the webhook config without it", func() {
+				rt.Test(rtesting.TableRow{
+					Key: key.Name,
+					Objects: []runtime.Object{
+						caSecret,
+					},
+					WantErr: true,
+				})
+			})
+		})
+
+		it("Leaves a valid webhook config alone", func() {
+			webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   key.Name,
+					Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+				},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					expectedWebhook(),
+				},
+			}
+
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+					webhookConfig,
+				},
+				WantErr: false,
+			})
+		})
+
+		it("Clears the problems of a webhook config found valid", func() {
+			webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   key.Name,
+					Labels: map[string]string{"app.kubernetes.io/managed-by": "knurse"},
+				},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					expectedWebhook(),
+				},
+			}
+			tracker.Detected(key.Name, []health.Problem{{Reason: "WebhookMissing"}}, true)
+
+			rt.Test(rtesting.TableRow{
+				Key: key.Name,
+				Objects: []runtime.Object{
+					caSecret,
+					webhookConfig,
+				},
+				WantErr: false,
+			})
+			require.Empty(t, tracker.Reports())
+		})
+	})
+
+	when("#ownedConfigNames", func() {
+		it("gives each webhook config to the controller of its first entry only", func() {
+			entries := []config.WebhookEntry{
+				{ConfigName: key.Name, Name: name, Path: path},
+				{ConfigName: multiKey.Name, Name: name, Path: path},
+				{ConfigName: multiKey.Name, Name: "other-webhook", Path: "/other-path"},
+				{ConfigName: "other-webhook-config", Name: "other-webhook", Path: "/other-path"},
+			}
+
+			assert.Equal(t, map[string]struct{}{key.Name: {}, multiKey.Name: {}}, ownedConfigNames(entries, path))
+			assert.Equal(t, map[string]struct{}{"other-webhook-config": {}}, ownedConfigNames(entries, "/other-path"))
+		})
+	})
+
+	when("#Admit", func() {
+		testPod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "object-meta",
+			},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{
+					{
+						Name:  "any-init-container",
+						Image: "image",
+						Env:   nil,
+					},
+				},
+				Containers: []corev1.Container{
+					{
+						Name:  "any-container",
+						Image: "image",
+						Env:   nil,
+					},
+				},
+			},
+		}
+
+		ctx := context.TODO()
+
+		it("sets the ca certs on all containers on the pod", func() {
+			bytes, err := json.Marshal(testPod)
+			require.NoError(t, err)
+
+			admissionRequest := &admissionv1.AdmissionRequest{
+				Name: "testAdmissionRequest",
+				Object: runtime.RawExtension{
+					Raw: bytes,
+				},
+				Operation: admissionv1.Create,
+				Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			}
+
+			r := &reconciler{
+				LeaderAwareFuncs: pkgreconciler.LeaderAwareFuncs{
+					// Have this reconciler enqueue our singleton whenever it becomes leader.
+					PromoteFunc: func(bkt pkgreconciler.Bucket, enq func(pkgreconciler.Bucket, types.NamespacedName)) error {
+						enq(bkt, key)
+						return nil
+					},
+				},
+
+				path:       path,
+				secretName: caSecretName,
+				injectors:  []injector.Injector{newInjector(t)},
+			}
+			r.Promote(pkgreconciler.UniversalBucket(), func(pkgreconciler.Bucket, types.NamespacedName) {})
+
+			response := r.Admit(ctx, admissionRequest)
+			wtesting.ExpectAllowed(t, response)
+
+			var actualPatch []jsonpatch.JsonPatchOperation
+			err = json.Unmarshal(response.Patch, &actualPatch)
+			require.NoError(t, err)
+
+			expectedJSON := `[
+  {
+    "op": "add",
+    "path": "/spec/volumes",
+    "value": [
+      {
+        "emptyDir": {},
+        "name": "ca-certs"
+      }
+    ]
+  },
+  {
+    "op": "add",
+    "path": "/spec/initContainers/1",
+    "value": {
+      "image": "image",
+      "name": "any-init-container",
+      "resources": {},
+      "volumeMounts": [
+        {
+          "mountPath": "/etc/ssl/certs",
+          "name": "ca-certs",
+          "readOnly": true
+        }
+      ]
+    }
+  },
+  {
+    "op": "replace",
+    "path": "/spec/initContainers/0/name",
+    "value": "setup-ca-certs"
+  },
+  {
+    "op": "replace",
+    "path": "/spec/initContainers/0/image",
+    "value": "zezaeoh/setup-ca-certs"
+  },
+  {
+    "op": "add",
+    "path": "/spec/initContainers/0/workingDir",
+    "value": "/workspace"
+  },
+  {
+    "op": "add",
+    "path": "/spec/initContainers/0/env",
+    "value": [
+      {
+         "name": "CA_CERTS_DATA",
+         "value": "-----BEGIN CERTIFICATE-----\nMIIBbjCCAROgAwIBAgIQE6ttkwhxiyWXdGyCYeXx2TAKBggqhkjOPQQDAjAVMRMw\nEQYDVQQDEwp6ZXphZW9oLmlvMB4XDTIyMDMwMTExMDMxM1oXDTMyMDIyNzExMDMx\nM1owFTETMBEGA1UEAxMKemV6YWVvaC5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEH\nA0IABHX/JsHeUP4N3nqPrvxomMfEAZuVNZ4gqUxkYfZ4zBeInce/l0VJ3zs6T1UF\nCCrfz4Ikh808Hqn0WOkuuTrjAfqjRTBDMA4GA1UdDwEB/wQEAwIBBjASBgNVHRMB\nAf8ECDAGAQH/AgEBMB0GA1UdDgQWBBRZCI0gAEYflEredZJdcb4g8TaCSzAKBggq\nhkjOPQQDAgNJADBGAiEA6r77RFykldPNKKIzyazuDjQltBQpP5FXJH8u3jDx3tYC\nIQDo8vaYB9ySwxkM4aQvEZVvOUZf/uYVkwenThmIxDbw8w==\n-----END CERTIFICATE-----"
+      },
+      {
+         "name": "CA_CERTS_DIGEST",
+         "value": "5c77d9ce8d0021732ec8bfe0f459d947fb307f9acabb4c24adfff0a9599918e5"
+      },
+      {
+         "name": "CA_CERTS_BUNDLE_NAME",
+         "value": "some-webhook"
+      }
+    ]
+  },
+  {
+    "op": "add",
+    "path": "/spec/initContainers/0/volumeMounts",
+    "value": [
+      {
+        "mountPath": "/workspace",
+        "name": "ca-certs"
+      }
+    ]
+  },
+  {
+    "op": "add",
+    "path": "/spec/initContainers/0/terminationMessagePolicy",
+    "value": "FallbackToLogsOnError"
+  },
+  {
+    "op": "add",
+    "path": "/spec/initContainers/0/imagePullPolicy",
+    "value": "IfNotPresent"
+  },
+  {
+    "op": "add",
+    "path": "/spec/containers/0/volumeMounts",
+    "value": [
+      {
+        "mountPath": "/etc/ssl/certs",
+        "name": "ca-certs",
+        "readOnly": true
+      }
+    ]
+  }
+]
+`
+			var expectedPatch []jsonpatch.JsonPatchOperation
+			err = json.Unmarshal([]byte(expectedJSON), &expectedPatch)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, expectedPatch, actualPatch)
+		})
+
+		when("the pod must not be mutated", func() {
+			var r *reconciler
+
+			it.Before(func() {
+				r = &reconciler{
+					path:      path,
+					injectors: []injector.Injector{newInjector(t)},
+
+					protectedNamespaces: []string{system.Namespace(), "kube-system"},
+					serviceAccountName:  "knurse",
+					exemptions: []config.Exemption{
+						{Name: "ci", Users: []string{"ci-bot"}},
+						{Name: "operators", Groups: []string{"system:operators"}},
+						{Name: "cni", ServiceAccounts: []string{"kube-network/cni"}, OwnerKinds: []string{"apps/DaemonSet"}},
+						{Name: "jobs", OwnerKinds: []string{"Job"}, ServiceAccounts: []string{"migrations"}},
+					},
+				}
+			})
+
+			admitPod := func(pod *corev1.Pod, namespace string, userInfo authenticationv1.UserInfo) *admissionv1.AdmissionResponse {
+				bytes, err := json.Marshal(pod)
+				require.NoError(t, err)
+
+				return r.Admit(ctx, &admissionv1.AdmissionRequest{
+					Name:      "testAdmissionRequest",
+					Namespace: namespace,
+					Object: runtime.RawExtension{
+						Raw: bytes,
+					},
+					Operation: admissionv1.Create,
+					Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+					UserInfo:  userInfo,
+				})
+			}
+			admit := func(namespace string, userInfo authenticationv1.UserInfo) *admissionv1.AdmissionResponse {
+				return admitPod(testPod, namespace, userInfo)
+			}
+			ownedPod := func(serviceAccount, apiVersion, kind string) *corev1.Pod {
+				pod := testPod.DeepCopy()
+				pod.Spec.ServiceAccountName = serviceAccount
+				pod.OwnerReferences = []metav1.OwnerReference{{
+					APIVersion: apiVersion,
+					Kind:       kind,
+					Name:       "owner",
+					Controller: ptr.Bool(true),
+				}}
+				return pod
+			}
+
+			it("skips pods of the knurse namespace", func() {
+				response := admit(system.Namespace(), authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+			})
+
+			it("skips pods of protected namespaces", func() {
+				response := admit("kube-system", authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+			})
+
+			it("skips pods of the knurse service account", func() {
+				pod := testPod.DeepCopy()
+				pod.Spec.ServiceAccountName = "knurse"
+				response := admitPod(pod, system.Namespace(), authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+
+				reason, ok := r.excluded(&admissionv1.AdmissionRequest{Namespace: system.Namespace()}, pod)
+				assert.True(t, ok)
+				assert.Equal(t, "pod of the knurse service account", reason)
+			})
+
+			it("mutates pods of a service account named as knurse's in other namespaces", func() {
+				pod := testPod.DeepCopy()
+				pod.Spec.ServiceAccountName = "knurse"
+				response := admitPod(pod, "some-namespace", authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.NotNil(t, response.Patch)
+			})
+
+			it("skips pods requested by exempted users", func() {
+				response := admit("some-namespace", authenticationv1.UserInfo{Username: "ci-bot"})
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+			})
+
+			it("skips pods requested by exempted groups", func() {
+				response := admit("some-namespace", authenticationv1.UserInfo{
+					Username: "someone",
+					Groups:   []string{"system:authenticated", "system:operators"},
+				})
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+			})
+
+			it("skips pods matching every field of an exemption", func() {
+				response := admitPod(ownedPod("cni", "apps/v1", "DaemonSet"), "kube-network", authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+
+				response = admitPod(ownedPod("migrations", "batch/v1", "Job"), "some-namespace", authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+			})
+
+			it("mutates pods matching only some fields of an exemption", func() {
+				response := admitPod(ownedPod("cni", "apps/v1", "DaemonSet"), "some-namespace", authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.NotNil(t, response.Patch)
+
+				response = admitPod(ownedPod("cni", "apps/v1", "ReplicaSet"), "kube-network", authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.NotNil(t, response.Patch)
+
+				response = admitPod(ownedPod("default", "batch/v1", "Job"), "some-namespace", authenticationv1.UserInfo{})
+				wtesting.ExpectAllowed(t, response)
+				require.NotNil(t, response.Patch)
+			})
+
+			it("mutates other pods", func() {
+				response := admit("some-namespace", authenticationv1.UserInfo{Username: "someone"})
+				wtesting.ExpectAllowed(t, response)
+				require.NotNil(t, response.Patch)
+			})
+		})
+
+		when("match conditions are configured", func() {
+			var r *reconciler
+
+			it.Before(func() {
+				var conditions []config.MatchCondition
+				require.NoError(t, yaml.Unmarshal([]byte(`
+- name: corp-images
+  expression: object.spec.containers.exists(c, c.image.startsWith("registry.corp/"))
+- name: opted-in-namespaces
+  expression: namespaceLabels["ca-certs"] == "enabled"
+`), &conditions))
+
+				listers := wtesting.NewListers([]runtime.Object{
+					&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "opted-in", Labels: map[string]string{"ca-certs": "enabled"}}},
+					&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "opted-out", Labels: map[string]string{"ca-certs": "disabled"}}},
+				})
+				r = &reconciler{
+					path:      path,
+					injectors: []injector.Injector{newInjector(t)},
+
+					matchConditions: conditions,
+					namespacelister: listers.GetNamespaceLister(),
+				}
+			})
+
+			admit := func(image, namespace string) *admissionv1.AdmissionResponse {
+				pod := testPod.DeepCopy()
+				pod.Spec.Containers[0].Image = image
+				bytes, err := json.Marshal(pod)
+				require.NoError(t, err)
+
+				return r.Admit(ctx, &admissionv1.AdmissionRequest{
+					Name:      "testAdmissionRequest",
+					Namespace: namespace,
+					Object: runtime.RawExtension{
+						Raw: bytes,
+					},
+					Operation: admissionv1.Create,
+					Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				})
+			}
+
+			it("mutates pods satisfying every condition", func() {
+				response := admit("registry.corp/app", "opted-in")
+				wtesting.ExpectAllowed(t, response)
+				require.NotNil(t, response.Patch)
+			})
+
+			it("skips pods not satisfying a condition", func() {
+				response := admit("docker.io/app", "opted-in")
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+
+				response = admit("registry.corp/app", "opted-out")
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+			})
+
+			it("skips pods failing to evaluate a condition", func() {
+				response := admit("registry.corp/app", "unknown")
+				wtesting.ExpectAllowed(t, response)
+				require.Nil(t, response.Patch)
+			})
+		})
+
+		when("several injectors are enabled", func() {
+			var (
+				r     *reconciler
+				calls []string
+			)
+
+			it.Before(func() {
+				var conditions []config.MatchCondition
+				require.NoError(t, yaml.Unmarshal([]byte(`
+- name: labelled
+  expression: has(object.metadata.labels) && object.metadata.labels["inject"] == "true"
+`), &conditions))
+
+				calls = nil
+				listers := wtesting.NewListers(nil)
+				r = &reconciler{
+					path: path,
+					injectors: []injector.Injector{
+						&fakeInjector{name: "first", calls: &calls, mutate: func(pod *corev1.Pod) {
+							pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "FIRST", Value: "1"})
+						}},
+						&fakeInjector{name: "second", calls: &calls, mutate: func(pod *corev1.Pod) {
+							pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "SECOND", Value: "2"})
+						}},
+						&fakeInjector{name: "labelled", calls: &calls, mutate: func(pod *corev1.Pod) {
+							pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "LABELLED", Value: "3"})
+						}},
+					},
+					injectorConditions: map[string][]config.MatchCondition{"labelled": conditions},
+					namespacelister:    listers.GetNamespaceLister(),
+				}
+			})
+
+			admit := func(pod *corev1.Pod) *admissionv1.AdmissionResponse {
+				bytes, err := json.Marshal(pod)
+				require.NoError(t, err)
+
+				return r.Admit(ctx, &admissionv1.AdmissionRequest{
+					Name:      "testAdmissionRequest",
+					Namespace: "some-namespace",
+					Object: runtime.RawExtension{
+						Raw: bytes,
+					},
+					Operation: admissionv1.Create,
+					Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				})
+			}
+
+			it("runs them in order and returns their changes as a single patch", func() {
+				response := admit(testPod)
+				wtesting.ExpectAllowed(t, response)
+				assert.Equal(t, []string{"first", "second"}, calls)
+
+				var actualPatch []jsonpatch.JsonPatchOperation
+				require.NoError(t, json.Unmarshal(response.Patch, &actualPatch))
+				assert.Equal(t, []jsonpatch.JsonPatchOperation{{
+					Operation: "add",
+					Path:      "/spec/containers/0/env",
+					Value: []interface{}{
+						map[string]interface{}{"name": "FIRST", "value": "1"},
+						map[string]interface{}{"name": "SECOND", "value": "2"},
+					},
+				}}, actualPatch)
+			})
+
+			it("runs the injectors whose match conditions are satisfied", func() {
+				pod := testPod.DeepCopy()
+				pod.Labels = map[string]string{"inject": "true"}
+
+				response := admit(pod)
+				wtesting.ExpectAllowed(t, response)
+				assert.Equal(t, []string{"first", "second", "labelled"}, calls)
+			})
+
+			it("skips the injectors which do not match the pod", func() {
+				r.injectors[0].(*fakeInjector).skip = true
+
+				response := admit(testPod)
+				wtesting.ExpectAllowed(t, response)
+				assert.Equal(t, []string{"second"}, calls)
+			})
+
+			it("only runs the ephemeral injectors on the ephemeral containers added to a pod", func() {
+				r.injectors = append(r.injectors, &fakeEphemeralInjector{&fakeInjector{name: "ephemeral", calls: &calls, mutate: func(pod *corev1.Pod) {
+					pod.Spec.EphemeralContainers[0].Image = "mirror/debug"
+				}}})
+				pod := testPod.DeepCopy()
+				pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", Image: "debug"},
+				}}
+				bytes, err := json.Marshal(pod)
+				require.NoError(t, err)
+
+				response := r.Admit(ctx, &admissionv1.AdmissionRequest{
+					Name:        "testAdmissionRequest",
+					Namespace:   "some-namespace",
+					Object:      runtime.RawExtension{Raw: bytes},
+					Operation:   admissionv1.Update,
+					Resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+					SubResource: "ephemeralcontainers",
+				})
+				wtesting.ExpectAllowed(t, response)
+				assert.Equal(t, []string{"ephemeral"}, calls)
+
+				var actualPatch []jsonpatch.JsonPatchOperation
+				require.NoError(t, json.Unmarshal(response.Patch, &actualPatch))
+				assert.Equal(t, []jsonpatch.JsonPatchOperation{{
+					Operation: "replace",
+					Path:      "/spec/ephemeralContainers/0/image",
+					Value:     "mirror/debug",
+				}}, actualPatch)
+			})
+
+			it("sizes the containers added by the injectors", func() {
+				listers := wtesting.NewListers(nil)
+				sizer, err := resources.New(config.Resources{
+					Requests: map[corev1.ResourceName]string{corev1.ResourceMemory: "16Mi"},
+				}, corelisters.NewLimitRangeLister(listers.IndexerFor(&corev1.LimitRange{})),
+					corelisters.NewResourceQuotaLister(listers.IndexerFor(&corev1.ResourceQuota{})))
+				require.NoError(t, err)
+				r.sizer = sizer
+				r.injectors = []injector.Injector{&fakeInjector{name: "sidecar", calls: &calls, mutate: func(pod *corev1.Pod) {
+					pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "sidecar", Image: "sidecar"})
+				}}}
+
+				response := admit(testPod)
+				wtesting.ExpectAllowed(t, response)
+
+				var actualPatch []jsonpatch.JsonPatchOperation
+				require.NoError(t, json.Unmarshal(response.Patch, &actualPatch))
+				assert.ElementsMatch(t, []jsonpatch.JsonPatchOperation{{
+					Operation: "add",
+					Path:      "/metadata/annotations",
+					Value: map[string]interface{}{
+						resources.Annotation: `{"source":"config","containers":{"sidecar":{"requests":{"memory":"16Mi"}}}}`,
+					},
+				}, {
+					Operation: "add",
+					Path:      "/spec/containers/1",
+					Value: map[string]interface{}{
+						"name":      "sidecar",
+						"image":     "sidecar",
+						"resources": map[string]interface{}{"requests": map[string]interface{}{"memory": "16Mi"}},
+					},
+				}}, actualPatch)
+			})
+
+			it("returns the warnings of the injectors", func() {
+				r.injectors[0].(*fakeInjector).warn = "first warning"
+				r.injectors[1].(*fakeInjector).warn = "second warning"
+
+				response := admit(testPod)
+				wtesting.ExpectAllowed(t, response)
+				assert.Equal(t, []string{"first warning", "second warning"}, response.Warnings)
+			})
+
+			it("rejects the pod when an injector fails", func() {
+				r.injectors[1].(*fakeInjector).err = errors.New("boom")
+
+				response := admit(testPod)
+				wtesting.ExpectFailsWith(t, response, "boom")
+			})
+		})
+	})
+}
+
+type fakeInjector struct {
+	name   string
+	calls  *[]string
+	skip   bool
+	err    error
+	warn   string
+	mutate func(pod *corev1.Pod)
+}
+
+func (f *fakeInjector) Name() string {
+	return f.name
+}
+
+func (f *fakeInjector) Match(context.Context, *corev1.Pod) (bool, error) {
+	return !f.skip, nil
+}
+
+func (f *fakeInjector) Inject(ctx context.Context, pod *corev1.Pod) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.warn != "" {
+		injector.Warn(ctx, "%s", f.warn)
+	}
+	*f.calls = append(*f.calls, f.name)
+	f.mutate(pod)
+	return nil
+}
+
+type fakeEphemeralInjector struct {
+	*fakeInjector
+}
+
+func (f *fakeEphemeralInjector) InjectEphemeral(ctx context.Context, pod *corev1.Pod) error {
+	return f.Inject(ctx, pod)
+}
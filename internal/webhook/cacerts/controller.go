@@ -1,78 +0,0 @@
-package cacerts
-
-import (
-	"context"
-	"github.com/zezaeoh/knurse/internal/config"
-
-	kubeclient "knative.dev/pkg/client/injection/kube/client"
-	mwhinformer "knative.dev/pkg/client/injection/kube/informers/admissionregistration/v1/mutatingwebhookconfiguration"
-	secretinformer "knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret"
-	pkgreconciler "knative.dev/pkg/reconciler"
-
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/tools/cache"
-	"knative.dev/pkg/controller"
-	"knative.dev/pkg/logging"
-	"knative.dev/pkg/system"
-	"knative.dev/pkg/webhook"
-)
-
-const queueName = "CaCerts"
-
-// NewAdmissionController constructs a reconciler
-func NewAdmissionController(
-	ctx context.Context,
-	cfg *config.Config,
-	wc func(context.Context) context.Context,
-) *controller.Impl {
-	client := kubeclient.Get(ctx)
-	mwhInformer := mwhinformer.Get(ctx)
-	secretInformer := secretinformer.Get(ctx)
-	options := webhook.GetOptions(ctx)
-
-	key := types.NamespacedName{Name: cfg.Webhook.ConfigName}
-
-	wh := &reconciler{
-		LeaderAwareFuncs: pkgreconciler.LeaderAwareFuncs{
-			// Have this reconciler enqueue our singleton whenever it becomes leader.
-			PromoteFunc: func(bkt pkgreconciler.Bucket, enq func(pkgreconciler.Bucket, types.NamespacedName)) error {
-				enq(bkt, key)
-				return nil
-			},
-		},
-
-		key:  key,
-		name: cfg.Webhook.CaCerts.Name,
-		path: cfg.Webhook.CaCerts.Path,
-
-		withContext: wc,
-
-		client:       client,
-		mwhlister:    mwhInformer.Lister(),
-		secretlister: secretInformer.Lister(),
-
-		secretName:        options.SecretName,
-		caCertData:        cfg.Webhook.CaCerts.Data,
-		setupCaCertsImage: cfg.Webhook.CaCerts.SetupCaCertsImage,
-	}
-
-	logger := logging.FromContext(ctx)
-	c := controller.NewImplFull(wh, controller.ControllerOptions{WorkQueueName: queueName, Logger: logger.Named(queueName)})
-
-	// Reconcile when the named MutatingWebhookConfiguration changes.
-	mwhInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
-		FilterFunc: controller.FilterWithName(cfg.Webhook.ConfigName),
-		// It doesn't matter what we enqueue because we will always Reconcile
-		// the named MWH resource.
-		Handler: controller.HandleAll(c.Enqueue),
-	})
-
-	// Reconcile when the cert bundle changes.
-	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
-		FilterFunc: controller.FilterWithNameAndNamespace(system.Namespace(), wh.secretName),
-		// It doesn't matter what we enqueue because we will always Reconcile
-		// the named MWH resource.
-		Handler: controller.HandleAll(c.Enqueue),
-	})
-	return c
-}